@@ -7,19 +7,32 @@ import (
 
 	"github.com/kinghrothgar/gobin/pkg/db"
 	"github.com/kinghrothgar/gobin/pkg/gob"
+	"github.com/kinghrothgar/gobin/pkg/store"
 )
 
+var bucketName = "gobin-io-test"
+
 func main() {
 	ctx := context.Background()
 	db, err := db.Connect(ctx, "host=127.0.0.1 port=26257 user=gobin dbname=gobin sslmode=disable")
 	if err != nil {
 		log.Fatal("failed to connect to database", err)
 	}
-	g := gob.NewGob(ctx, db)
-	_, err = g.Upload(os.Stdin, "asdf")
+	backend, err := store.NewGCSBackend(ctx, bucketName)
+	if err != nil {
+		log.Fatal("failed to connect to store", err)
+	}
+	g := gob.NewGob(ctx, db, backend)
+	meta, err := g.Upload(os.Stdin, &gob.UploadOptions{EncryptKey: "asdf", Filename: "E.coli"})
+	if err != nil {
+		log.Fatal(err)
+	}
 	f, err := os.OpenFile("E.coli.down", os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		log.Fatal(err)
+	}
 	defer f.Close()
-	_, err = g.Download(f, "7kerps", "")
+	err = g.Download(f, meta, "asdf")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -2,75 +2,146 @@ package main
 
 import (
 	"context"
-	"math/rand"
+	"expvar"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
-	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/kinghrothgar/gobin/pkg/config"
 	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/kinghrothgar/gobin/pkg/gob"
 	"github.com/kinghrothgar/gobin/pkg/gobin"
+	"github.com/kinghrothgar/gobin/pkg/store"
 	"github.com/levenlabs/go-llog"
 )
 
-var (
-	shutDownGracePeriod = time.Second * 120
-	textTmplsPath       = "./templates/textTemplates.tmpl"
-	htmlTmplsPath       = "./templates/htmlTemplates.tmpl"
-	domain              = "127.0.0.1:8081"
-	staticDir           = "./static/"
-)
-
 func main() {
 
 	ctx := context.Background()
-	llog.SetLevelFromString("DEBUG")
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	llog.SetLevelFromString(cfg.LogLevel)
 
-	rand.Seed(time.Now().UTC().UnixNano())
+	db, err := newDB(ctx, &cfg.DB)
+	if err != nil {
+		llog.Fatal("failed to connect to db", llog.KV{"err": err})
+	}
 
-	tmpls, err := gobin.NewTemplates(htmlTmplsPath, textTmplsPath, domain)
+	if len(cfg.Args) > 0 {
+		if cfg.Args[0] != "migrate" {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", cfg.Args[0])
+			os.Exit(2)
+		}
+		if err := migrate(ctx, db, cfg.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if cfg.DB.AutoMigrate {
+		if err := autoMigrate(ctx, db); err != nil {
+			llog.Fatal("failed to migrate db", llog.ErrKV(err))
+		}
+	}
+
+	tmpls, err := gobin.NewTemplates(cfg.HTMLTemplates, cfg.TextTemplates, cfg.Domain)
 	if err != nil {
 		llog.Fatal("failed to load templates", llog.ErrKV(err))
 	}
 
-	db, err := db.Connect(ctx, "host=127.0.0.1 port=26257 user=gobin dbname=gobin sslmode=disable")
+	backend, err := newBackend(ctx, &cfg.Store)
 	if err != nil {
-		llog.Fatal("failed to connect to db", llog.KV{"err": err})
+		llog.Fatal("failed to connect to store", llog.KV{"err": err})
+	}
+
+	reaperCtx, stopReaper := context.WithCancel(ctx)
+	defer stopReaper()
+	if cfg.Reaper.Interval > 0 {
+		if cfg.Reaper.StaleUpload < 2*gob.UploadHeartbeat {
+			llog.Fatal("reaper stale upload has to be at least twice the upload heartbeat", llog.KV{"heartbeat": gob.UploadHeartbeat})
+		}
+		go gob.NewGob(reaperCtx, db, backend).RunReaper(cfg.Reaper.Interval, cfg.Reaper.StaleUpload, cfg.Reaper.BatchSize)
+	}
+
+	if cfg.MetricsAddr != "" {
+		go func() {
+			llog.Info("serving metrics", llog.KV{"addr": cfg.MetricsAddr})
+			mux := http.NewServeMux()
+			mux.Handle("/debug/vars", expvar.Handler())
+			if err := http.ListenAndServe(cfg.MetricsAddr, mux); err != nil {
+				llog.Error("failed to serve metrics", llog.ErrKV(err))
+			}
+		}()
 	}
 
+	limits := &gobin.Limits{
+		MaxGobSize: cfg.MaxGobSize,
+		DefaultTTL: cfg.DefaultTTL,
+		MaxTTL:     cfg.MaxTTL,
+	}
+	if cfg.DailyQuota > 0 {
+		trustedProxies, err := gobin.ParseTrustedProxies(cfg.TrustedProxies)
+		if err != nil {
+			llog.Fatal("failed to parse trusted proxies", llog.KV{"err": err})
+		}
+		limits.Quota = gobin.NewQuota(cfg.DailyQuota, trustedProxies)
+	}
+	downloads := &gobin.Downloads{Gzip: cfg.GzipDownloads}
+
 	r := mux.NewRouter()
-	routeToDir(r, "/browserconfig.xml", staticDir)
-	routeToDir(r, "/robots.txt", staticDir)
-	routeToDir(r, "/sitemap.xml", staticDir)
+	routeToDir(r, "/browserconfig.xml", cfg.StaticDir)
+	routeToDir(r, "/robots.txt", cfg.StaticDir)
+	routeToDir(r, "/sitemap.xml", cfg.StaticDir)
 
 	r.Handle("/", gobin.GetRootHandler(db, tmpls)).Methods("GET")
-	r.Handle("/", gobin.PostGobHandler(db, tmpls)).Methods("POST")
-	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir(staticDir))))
-	r.Handle("/{id:[a-zA-Z0-9]}", gobin.GetGobHandler(db)).Methods("GET")
-	r.Handle("/expire/{secret}", gobin.GetExpireHandler(db, tmpls)).Methods("GET")
+	r.Handle("/", gobin.PostGobHandler(db, backend, tmpls, limits)).Methods("POST", "PUT")
+	r.Handle("/new/gob", gobin.GetFormHandler(tmpls)).Methods("GET")
+	r.Handle("/{filename}", gobin.PostGobHandler(db, backend, tmpls, limits)).Methods("PUT")
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir(cfg.StaticDir))))
+	r.Handle("/{id:[a-zA-Z0-9]+}", gobin.GetGobHandler(db, backend, tmpls, downloads)).Methods("GET", "HEAD")
+	r.Handle("/{id:[a-zA-Z0-9]+}.{lang}", gobin.GetGobHandler(db, backend, tmpls, downloads)).Methods("GET", "HEAD")
+	r.Handle("/{id:[a-zA-Z0-9]+}", gobin.DeleteGobHandler(db, backend, tmpls)).Methods("DELETE")
+	r.Handle("/append/{secret}", gobin.AppendGobHandler(db, backend, tmpls, limits)).Methods("POST", "PUT")
+	r.Handle("/expire/{secret}", gobin.GetExpireHandler(db, backend, tmpls)).Methods("GET")
+	r.Handle("/delete/{secret}", gobin.GetDeleteHandler(db, backend, tmpls)).Methods("GET")
+	r.Handle("/delete/{secret}", gobin.PostDeleteHandler(db, backend, tmpls)).Methods("POST")
+	r.Handle("/{horde:[a-zA-Z0-9_-]+}", gobin.PostGobHandler(db, backend, tmpls, limits)).Methods("POST")
+	r.Handle("/horde/{horde:[a-zA-Z0-9_-]+}", gobin.GetHordeHandler(db, backend, tmpls)).Methods("GET")
+	r.Handle("/horde/{horde:[a-zA-Z0-9_-]+}.{format:tar|zip}", gobin.GetHordeArchiveHandler(db, backend, tmpls)).Methods("GET")
+	r.Handle("/horde/expire/{secret}", gobin.GetHordeExpireHandler(db, backend, tmpls)).Methods("GET")
+
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.Handle("/gobs", gobin.APICreateGobHandler(db, backend, tmpls, limits)).Methods("POST")
+	api.Handle("/gobs/{id:[a-zA-Z0-9]+}", gobin.APIGetGobHandler(db, backend, tmpls)).Methods("GET")
+	api.Handle("/gobs/{id:[a-zA-Z0-9]+}", gobin.APIDeleteGobHandler(db, backend)).Methods("DELETE")
+	api.Handle("/gobs/{id:[a-zA-Z0-9]+}/content", gobin.APIGetGobContentHandler(db, backend, downloads)).Methods("GET", "HEAD")
+	api.Handle("/gobs/{id:[a-zA-Z0-9]+}/expire", gobin.APIExpireGobHandler(db, backend, tmpls)).Methods("POST")
 	//mux.Get("/", http.HandlerFunc(handler.GetRoot))
 	//mux.Get("/:uid", http.HandlerFunc(handler.GetGob))
-	//mux.Get("/delete/:token", http.HandlerFunc(handler.DelGob))
-	//mux.Post("/append/:token", http.HandlerFunc(handler.AppendGob))
-	//mux.Get("/horde/:horde", http.HandlerFunc(handler.GetHorde))
-	//mux.Get("/new/gob", http.HandlerFunc(handler.GetForm))
 	//mux.Post("/", http.HandlerFunc(handler.PostGob))
-	//// TODO: Should I post to /horde/:horde
-	//mux.Post("/:horde", http.HandlerFunc(handler.PostHordeGob))
 
 	srv := &http.Server{
-		Addr: "127.0.0.1:8081",
+		Addr: cfg.ListenAddr,
 		// Need to figure out how high to set this
-		WriteTimeout: time.Second * 120,
-		ReadTimeout:  time.Second * 120,
-		IdleTimeout:  time.Second * 60,
-		Handler:      r, // Pass our instance of gorilla/mux in.
+		WriteTimeout:   cfg.WriteTimeout,
+		ReadTimeout:    cfg.ReadTimeout,
+		IdleTimeout:    cfg.IdleTimeout,
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
+		Handler:        r, // Pass our instance of gorilla/mux in.
 	}
 
 	// Run our server in a goroutine so that it doesn't block.
 	go func() {
-		llog.Info("gobin is listening")
+		llog.Info("gobin is listening", llog.KV{"addr": cfg.ListenAddr})
 		if err := srv.ListenAndServe(); err != nil {
 			if err != http.ErrServerClosed {
 				llog.Fatal("failed to listen and serve", llog.KV{"err": err})
@@ -88,8 +159,9 @@ func main() {
 	<-c
 
 	llog.Info("shutting down")
+	stopReaper()
 	// Create a deadline to wait for.
-	ctx, cancel := context.WithTimeout(ctx, shutDownGracePeriod)
+	ctx, cancel := context.WithTimeout(ctx, cfg.ShutdownGracePeriod)
 	defer cancel()
 	// Doesn't block if no connections, but will otherwise wait
 	// until the timeout deadline.
@@ -103,6 +175,38 @@ func main() {
 	os.Exit(0)
 }
 
+func newDB(ctx context.Context, cfg *config.DB) (db.DB, error) {
+	switch cfg.Driver {
+	case "postgres":
+		return db.Connect(ctx, cfg.DSN)
+	case "sqlite3":
+		return db.ConnectSQLite(ctx, cfg.DSN)
+	case "memory":
+		return db.NewMemoryDB(), nil
+	}
+	return nil, fmt.Errorf("unknown db driver %q", cfg.Driver)
+}
+
+func newBackend(ctx context.Context, cfg *config.Store) (store.Backend, error) {
+	switch cfg.Backend {
+	case "gcs":
+		return store.NewGCSBackend(ctx, cfg.Bucket)
+	case "fs":
+		return store.NewFSBackend(cfg.Dir)
+	case "s3":
+		return store.NewS3Backend(ctx, store.S3Config{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.Bucket,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			Insecure:  cfg.S3.Insecure,
+			PartSize:  cfg.S3.PartSize,
+		})
+	}
+	return nil, fmt.Errorf("unknown store backend %q", cfg.Backend)
+}
+
 func routeToDir(r *mux.Router, path string, dir string) {
 	r.PathPrefix(path).Handler(http.FileServer(http.Dir(dir)))
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/kinghrothgar/gobin/pkg/db"
+)
+
+const migrateUsage = "usage: gobin [flags] migrate up|down|status|to <version>"
+
+// migrate runs the migrate subcommand with args, the arguments after
+// "migrate"
+func migrate(ctx context.Context, d db.DB, args []string) error {
+	m, ok := d.(db.Migrator)
+	if !ok {
+		return fmt.Errorf("db driver has no schema to migrate")
+	}
+	if len(args) == 0 {
+		return fmt.Errorf(migrateUsage)
+	}
+	current, err := m.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	version := current
+	switch {
+	case args[0] == "status" && len(args) == 1:
+		fmt.Printf("schema version %d, latest is %d\n", current, db.LatestSchemaVersion())
+		return nil
+	case args[0] == "up" && len(args) == 1:
+		version = db.LatestSchemaVersion()
+	case args[0] == "down" && len(args) == 1:
+		if current == 0 {
+			return fmt.Errorf("schema is already at version 0")
+		}
+		version = current - 1
+	case args[0] == "to" && len(args) == 2:
+		if version, err = strconv.Atoi(args[1]); err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+	default:
+		return fmt.Errorf(migrateUsage)
+	}
+	if err := m.Migrate(ctx, version); err != nil {
+		return err
+	}
+	fmt.Printf("migrated schema from version %d to %d\n", current, version)
+	return nil
+}
+
+// autoMigrate brings the schema of d up to the latest version, if it has one
+func autoMigrate(ctx context.Context, d db.DB) error {
+	m, ok := d.(db.Migrator)
+	if !ok {
+		return nil
+	}
+	return m.Migrate(ctx, db.LatestSchemaVersion())
+}
@@ -58,7 +58,11 @@ func bucket() {
 
 func object() {
 	ctx := context.Background()
-	obj, err := store.NewObject(ctx, bucketName, "data")
+	backend, err := store.NewGCSBackend(ctx, bucketName)
+	if err != nil {
+		log.Fatalf("failed to get new backend: %v", err)
+	}
+	obj := store.NewObject(backend, "data")
 	w, err := obj.NewWriter(ctx)
 	if err != nil {
 		log.Fatalf("failed to get object writer: %v", err)
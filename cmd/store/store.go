@@ -19,10 +19,11 @@ var bucketName = "gobin-io-test"
 
 func test() {
 	ctx := context.Background()
-	obj, err := store.NewObject(ctx, bucketName, "data")
+	backend, err := store.NewGCSBackend(ctx, bucketName)
 	if err != nil {
-		log.Fatalf("failed to get new object: %v", err)
+		log.Fatalf("failed to get new backend: %v", err)
 	}
+	obj := store.NewObject(backend, "data")
 	_, err = obj.Exists(ctx)
 	if err != nil {
 		log.Fatalf("%v", err)
@@ -1,14 +1,6 @@
 CREATE DATABASE gobin;
-create table gobin.gob_metadata (
-	id          STRING PRIMARY KEY,
-	secret      STRING UNIQUE NOT NULL,
-	encrypted   BOOL,
-	create_date  TIMESTAMP,
-	expire_date  TIMESTAMP,
-	size        INT,
-    filename     STRING,
-	content_type STRING,
-	owner_id     INT,
-);
 
-GRANT INSERT, SELECT, UPDATE, DELETE ON TABLE gobin.gob_metadata TO gobin;
+-- Tables are created and upgraded by gobin itself, on startup when
+-- db.auto-migrate is set or with "gobin migrate up". Tables created in the
+-- database inherit these privileges.
+GRANT CREATE, DROP, INSERT, SELECT, UPDATE, DELETE ON DATABASE gobin TO gobin;
@@ -0,0 +1,296 @@
+// Package config loads the gobin server configuration from flags, environment
+// variables and a yaml file.
+//
+// Every option is a flag, e.g. -store.backend. The same option can be set in
+// the environment as GOBIN_STORE_BACKEND or in the config file as
+//
+//	store:
+//	  backend: fs
+//
+// Flags take precedence over the environment, which takes precedence over the
+// config file.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/levenlabs/errctx"
+	"gopkg.in/yaml.v2"
+)
+
+// Config of the gobin server
+type Config struct {
+	ListenAddr string
+	// Domain is what urls given to users are built with
+	Domain   string
+	LogLevel string
+
+	HTMLTemplates string
+	TextTemplates string
+	StaticDir     string
+
+	ReadTimeout         time.Duration
+	WriteTimeout        time.Duration
+	IdleTimeout         time.Duration
+	ShutdownGracePeriod time.Duration
+
+	// MaxHeaderBytes is passed to http.Server, 0 uses the default
+	MaxHeaderBytes int
+	// MaxGobSize is the largest upload accepted in bytes, 0 is no limit
+	MaxGobSize int64
+	// DefaultTTL is how long gobs live when the uploader doesn't say, 0 is
+	// forever
+	DefaultTTL time.Duration
+	// MaxTTL is the longest an uploader can ask a gob to live, 0 is no limit
+	MaxTTL time.Duration
+	// DailyQuota is how many bytes each client can upload a day, 0 is no
+	// limit
+	DailyQuota int64
+	// TrustedProxies is a comma separated list of the ips and CIDRs of
+	// proxies whose X-Real-IP header is used to tell clients apart
+	TrustedProxies string
+	// GzipDownloads transcodes text gobs to gzip for clients that don't
+	// accept zstd
+	GzipDownloads bool
+
+	// MetricsAddr is where expvar metrics are served at /debug/vars, empty
+	// disables it
+	MetricsAddr string
+
+	DB     DB
+	Store  Store
+	Reaper Reaper
+
+	// Args are what is left after the flags, e.g. a subcommand
+	Args []string
+}
+
+// DB config
+type DB struct {
+	// Driver is one of "postgres" (also for CockroachDB), "sqlite3" or
+	// "memory"
+	Driver string
+	// DSN is the data source name for postgres and the file path for sqlite3
+	DSN string
+	// AutoMigrate migrates the schema to the latest version on startup
+	AutoMigrate bool
+}
+
+// Reaper config for the deleting of expired gobs
+type Reaper struct {
+	// Interval between runs, 0 disables the reaper
+	Interval  time.Duration
+	BatchSize int
+	// StaleUpload is how long an upload can go without touching its
+	// metadata before it's taken to have died and is deleted
+	StaleUpload time.Duration
+}
+
+// Store config
+type Store struct {
+	// Backend is one of "gcs", "fs" or "s3"
+	Backend string
+	// Bucket is used by the gcs and s3 backends
+	Bucket string
+	// Dir is the root directory of the fs backend
+	Dir string
+	S3  S3
+}
+
+// S3 config for the s3 store backend
+type S3 struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Insecure  bool
+	PartSize  int64
+}
+
+// Default returns the config used when nothing is set
+func Default() *Config {
+	return &Config{
+		ListenAddr:          "127.0.0.1:8081",
+		Domain:              "127.0.0.1:8081",
+		LogLevel:            "DEBUG",
+		HTMLTemplates:       "./templates/htmlTemplates.tmpl",
+		TextTemplates:       "./templates/textTemplates.tmpl",
+		StaticDir:           "./static/",
+		ReadTimeout:         time.Second * 120,
+		WriteTimeout:        time.Second * 120,
+		IdleTimeout:         time.Second * 60,
+		ShutdownGracePeriod: time.Second * 120,
+		DB: DB{
+			Driver:      "postgres",
+			DSN:         "host=127.0.0.1 port=26257 user=gobin dbname=gobin sslmode=disable",
+			AutoMigrate: true,
+		},
+		Reaper: Reaper{
+			Interval:    time.Minute * 10,
+			BatchSize:   100,
+			StaleUpload: time.Minute * 10,
+		},
+		Store: Store{
+			Backend: "gcs",
+			Bucket:  "gobin-io-test",
+			Dir:     "./data/",
+			S3: S3{
+				Endpoint:  "s3.amazonaws.com",
+				Region:    "us-east-1",
+				AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+				SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			},
+		},
+	}
+}
+
+// flagSet returns a FlagSet that sets the fields of cfg
+func flagSet(cfg *Config, configPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("gobin", flag.ContinueOnError)
+	fs.StringVar(configPath, "config", "", "path to a yaml config file")
+
+	fs.StringVar(&cfg.ListenAddr, "listen-addr", cfg.ListenAddr, "address to listen on")
+	fs.StringVar(&cfg.Domain, "domain", cfg.Domain, "domain used in urls given to users")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "DEBUG, INFO, WARN, ERROR or FATAL")
+
+	fs.StringVar(&cfg.HTMLTemplates, "html-templates", cfg.HTMLTemplates, "path to the html templates")
+	fs.StringVar(&cfg.TextTemplates, "text-templates", cfg.TextTemplates, "path to the text templates")
+	fs.StringVar(&cfg.StaticDir, "static-dir", cfg.StaticDir, "directory of static files")
+
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", cfg.ReadTimeout, "http server read timeout")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", cfg.WriteTimeout, "http server write timeout")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", cfg.IdleTimeout, "http server idle timeout")
+	fs.DurationVar(&cfg.ShutdownGracePeriod, "shutdown-grace-period", cfg.ShutdownGracePeriod, "how long to wait for requests to finish on shutdown")
+
+	fs.IntVar(&cfg.MaxHeaderBytes, "max-header-bytes", cfg.MaxHeaderBytes, "max size of request headers, 0 is the net/http default")
+	fs.Int64Var(&cfg.MaxGobSize, "max-gob-size", cfg.MaxGobSize, "max size of an uploaded gob in bytes, 0 is no limit")
+	fs.DurationVar(&cfg.DefaultTTL, "default-ttl", cfg.DefaultTTL, "how long gobs live when the uploader doesn't give a ttl, 0 is forever")
+	fs.DurationVar(&cfg.MaxTTL, "max-ttl", cfg.MaxTTL, "the longest ttl an uploader can give, 0 is no limit")
+	fs.Int64Var(&cfg.DailyQuota, "daily-quota", cfg.DailyQuota, "bytes each client ip can upload a day, 0 is no limit")
+	fs.StringVar(&cfg.TrustedProxies, "trusted-proxies", cfg.TrustedProxies, "comma separated ips and CIDRs of proxies whose X-Real-IP header is trusted")
+	fs.BoolVar(&cfg.GzipDownloads, "gzip-downloads", cfg.GzipDownloads, "gzip text gobs for clients that don't accept zstd")
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", cfg.MetricsAddr, "address to serve expvar metrics on, empty disables it")
+
+	fs.StringVar(&cfg.DB.Driver, "db.driver", cfg.DB.Driver, "postgres, sqlite3 or memory")
+	fs.StringVar(&cfg.DB.DSN, "db.dsn", cfg.DB.DSN, "metadata database data source name, or file path for sqlite3")
+	fs.BoolVar(&cfg.DB.AutoMigrate, "db.auto-migrate", cfg.DB.AutoMigrate, "migrate the schema to the latest version on startup")
+
+	fs.DurationVar(&cfg.Reaper.Interval, "reaper.interval", cfg.Reaper.Interval, "how often expired gobs are deleted, 0 disables it")
+	fs.IntVar(&cfg.Reaper.BatchSize, "reaper.batch-size", cfg.Reaper.BatchSize, "how many expired gobs are fetched at a time")
+	fs.DurationVar(&cfg.Reaper.StaleUpload, "reaper.stale-upload", cfg.Reaper.StaleUpload, "how long an upload can go without a heartbeat, sent every minute, before it's deleted")
+
+	fs.StringVar(&cfg.Store.Backend, "store.backend", cfg.Store.Backend, "gcs, fs or s3")
+	fs.StringVar(&cfg.Store.Bucket, "store.bucket", cfg.Store.Bucket, "bucket for the gcs and s3 backends")
+	fs.StringVar(&cfg.Store.Dir, "store.dir", cfg.Store.Dir, "root directory of the fs backend")
+	fs.StringVar(&cfg.Store.S3.Endpoint, "store.s3.endpoint", cfg.Store.S3.Endpoint, "host[:port] of the s3 service")
+	fs.StringVar(&cfg.Store.S3.Region, "store.s3.region", cfg.Store.S3.Region, "s3 region")
+	fs.StringVar(&cfg.Store.S3.AccessKey, "store.s3.access-key", cfg.Store.S3.AccessKey, "s3 access key, defaults to AWS_ACCESS_KEY_ID")
+	fs.StringVar(&cfg.Store.S3.SecretKey, "store.s3.secret-key", cfg.Store.S3.SecretKey, "s3 secret key, defaults to AWS_SECRET_ACCESS_KEY")
+	fs.BoolVar(&cfg.Store.S3.Insecure, "store.s3.insecure", cfg.Store.S3.Insecure, "talk to s3 without tls")
+	fs.Int64Var(&cfg.Store.S3.PartSize, "store.s3.part-size", cfg.Store.S3.PartSize, "s3 multipart upload part size in bytes, 0 is the default")
+	return fs
+}
+
+// envName returns the environment variable for the flag name
+func envName(name string) string {
+	r := strings.NewReplacer(".", "_", "-", "_")
+	return "GOBIN_" + strings.ToUpper(r.Replace(name))
+}
+
+// Load returns the config from the defaults, the config file, the
+// environment and args, in increasing order of precedence. args should not
+// include the program name.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	var configPath string
+	fs := flagSet(cfg, &configPath)
+	// Flags are parsed first only to find the config file, and again at the
+	// end so they win
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if configPath == "" {
+		configPath = os.Getenv(envName("config"))
+	}
+	if configPath != "" {
+		if err := loadFile(fs, configPath); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok && err == nil {
+			if setErr := fs.Set(f.Name, v); setErr != nil {
+				err = errctx.Mark(fmt.Errorf("invalid %s: %v", envName(f.Name), setErr))
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	cfg.Args = fs.Args()
+	return cfg, nil
+}
+
+// loadFile sets the flags in fs from the yaml file at path
+func loadFile(fs *flag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errctx.Mark(err)
+	}
+	raw := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return errctx.Mark(fmt.Errorf("failed to parse %s: %v", path, err))
+	}
+	values := map[string]string{}
+	if err := flatten("", raw, values); err != nil {
+		return errctx.Mark(fmt.Errorf("failed to parse %s: %v", path, err))
+	}
+	// Sorted so errors are deterministic
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "config" || fs.Lookup(name) == nil {
+			return errctx.Mark(fmt.Errorf("unknown option %q in %s", name, path))
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			return errctx.Mark(fmt.Errorf("invalid %s in %s: %v", name, path, err))
+		}
+	}
+	return nil
+}
+
+// flatten turns nested yaml maps into flag names joined with dots
+func flatten(prefix string, raw map[interface{}]interface{}, values map[string]string) error {
+	for k, v := range raw {
+		name := fmt.Sprint(k)
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		switch v := v.(type) {
+		case map[interface{}]interface{}:
+			if err := flatten(name, v, values); err != nil {
+				return err
+			}
+		case []interface{}:
+			return fmt.Errorf("%s can not be a list", name)
+		case nil:
+			values[name] = ""
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
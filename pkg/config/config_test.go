@@ -0,0 +1,93 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gobin.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+listen-addr: file:1
+domain: file.example.com
+log-level: WARN
+reaper:
+  interval: 1m
+store:
+  s3:
+    region: file-region
+`)
+	t.Setenv("GOBIN_DOMAIN", "env.example.com")
+	t.Setenv("GOBIN_LOG_LEVEL", "ERROR")
+	t.Setenv("GOBIN_REAPER_INTERVAL", "2m")
+	cfg, err := Load([]string{"-config", path, "-log-level", "INFO", "migrate", "up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"default", cfg.TextTemplates, Default().TextTemplates},
+		{"file", cfg.ListenAddr, "file:1"},
+		{"nested file", cfg.Store.S3.Region, "file-region"},
+		{"env over file", cfg.Domain, "env.example.com"},
+		{"nested env over file", cfg.Reaper.Interval, 2 * time.Minute},
+		{"flag over env", cfg.LogLevel, "INFO"},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if len(cfg.Args) != 2 || cfg.Args[0] != "migrate" || cfg.Args[1] != "up" {
+		t.Fatalf("got args %v", cfg.Args)
+	}
+
+	// The file can be given in the environment too
+	t.Setenv("GOBIN_CONFIG", path)
+	if cfg, err = Load(nil); err != nil {
+		t.Fatal(err)
+	} else if cfg.ListenAddr != "file:1" {
+		t.Fatalf("got %q from GOBIN_CONFIG's file", cfg.ListenAddr)
+	}
+}
+
+func TestLoadS3Keys(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "aws-access")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "aws-secret")
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if cfg.Store.S3.AccessKey != "aws-access" || cfg.Store.S3.SecretKey != "aws-secret" {
+		t.Fatalf("got %q %q, want the AWS_* keys", cfg.Store.S3.AccessKey, cfg.Store.S3.SecretKey)
+	}
+	t.Setenv("GOBIN_STORE_S3_ACCESS_KEY", "gobin-access")
+	if cfg, err = Load(nil); err != nil {
+		t.Fatal(err)
+	} else if cfg.Store.S3.AccessKey != "gobin-access" || cfg.Store.S3.SecretKey != "aws-secret" {
+		t.Fatalf("got %q %q", cfg.Store.S3.AccessKey, cfg.Store.S3.SecretKey)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	if _, err := Load([]string{"-config", writeConfig(t, "nope: 1\n")}); err == nil {
+		t.Fatal("loaded an unknown option")
+	}
+	if _, err := Load([]string{"-config", writeConfig(t, "reaper:\n  interval: [1m]\n")}); err == nil {
+		t.Fatal("loaded a list")
+	}
+	t.Setenv("GOBIN_REAPER_INTERVAL", "soon")
+	if _, err := Load(nil); err == nil {
+		t.Fatal("loaded an invalid env value")
+	}
+}
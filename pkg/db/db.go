@@ -2,22 +2,122 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 
 	"github.com/lib/pq"
 )
 
-type DB struct {
+// DB is a store of gob metadata
+type DB interface {
+	InsertMetadata(meta *Metadata) error
+	GetMetadataByID(id string) (*Metadata, error)
+	// GetMetadataBySecret, like all the BySecret methods, looks the secret
+	// up by its HashSecret. The metadata returned never has its Secret set.
+	GetMetadataBySecret(secret string) (*Metadata, error)
+	DeleteMetadataBySecret(secret string) error
+	DeleteMetadataByID(id string) error
+	// MarkMetadataDeleted makes the metadata a tombstone deleted, and
+	// expired, at t. It returns ErrNotFound if it already is one.
+	MarkMetadataDeleted(id string, t time.Time) error
+	// DeleteTombstones deletes the metadata of gobs deleted before t and
+	// returns how many there were
+	DeleteTombstones(t time.Time) (int64, error)
+	// UpdateMetadata updates everything but the secret and the content,
+	// which is UpdateContent's. It returns ErrNotFound for tombstones.
+	UpdateMetadata(meta *Metadata) error
+	// GetExpiredMetadata returns up to limit metadata that expired before
+	// t or have no views left, oldest first. Tombstones aren't included.
+	GetExpiredMetadata(t time.Time, limit int) ([]*Metadata, error)
+	// DecrementViewsLeft atomically takes a view from a gob with limited
+	// views and returns how many are left. It returns ErrNotFound if there
+	// were none left, so only one caller can get the last view.
+	DecrementViewsLeft(id string) (int64, error)
+	// IncrementViewsLeft gives back a view taken by DecrementViewsLeft
+	IncrementViewsLeft(id string) error
+	// UpdateContent sets the size, parts, content hash, hash state and
+	// modify date of the gob of meta, and clears Uploading, if its size and
+	// parts are still oldSize and oldParts. It returns ErrConflict if they
+	// aren't, since then the content was changed by someone else too, and
+	// ErrNotFound if the gob was deleted, or has expired and isn't
+	// uploading. An upload is finished even if it expired meanwhile, for the
+	// reaper to take.
+	UpdateContent(meta *Metadata, oldSize int64, oldParts string) error
+	// TouchUpload sets the modify date of the gob with id to t while it's
+	// uploading, to show the upload is still going. It returns ErrNotFound
+	// if it isn't uploading.
+	TouchUpload(id string, t time.Time) error
+	// GetStaleUploads returns up to limit metadata of gobs still uploading
+	// that weren't touched, or created if never touched, since t, oldest
+	// first. Tombstones aren't included.
+	GetStaleUploads(t time.Time, limit int) ([]*Metadata, error)
+
+	// InsertHorde returns ErrConflict if the name or secret is taken
+	InsertHorde(horde *Horde) error
+	GetHorde(name string) (*Horde, error)
+	GetHordeBySecret(secret string) (*Horde, error)
+	// GetHordeMetadata returns the metadata of the gobs in the horde, oldest
+	// first, whether they've expired or not
+	GetHordeMetadata(name string) ([]*Metadata, error)
+	// ExpireHorde sets the expire date of the horde, and of its gobs that
+	// would expire after it, to t
+	ExpireHorde(name string, t time.Time) error
+	// DeleteExpiredHordes deletes the hordes that expired before t and
+	// returns how many there were
+	DeleteExpiredHordes(t time.Time) (int64, error)
+}
+
+var (
+	// ErrNotFound is returned when there is no metadata with the id or
+	// secret
+	ErrNotFound = errors.New("metadata not found")
+	// ErrConflict is returned by InsertMetadata and InsertHorde when the
+	// id, name or secret is already taken
+	ErrConflict = errors.New("metadata id or secret already exists")
+)
+
+// notFound turns sql.ErrNoRows into ErrNotFound
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// SQLDB is a DB backed by Postgres, CockroachDB or SQLite
+type SQLDB struct {
 	*sqlx.DB
 }
 
+// utcNull is utc for a nullable time. Every time SQLDB stores or compares
+// is UTC, since go-sqlite3 binds times as text in their own zone, which
+// SQLite compares as strings, and Postgres TIMESTAMP drops the zone.
+func utcNull(t sql.NullTime) sql.NullTime {
+	if t.Valid {
+		t.Time = t.Time.UTC()
+	}
+	return t
+}
+
+// utc returns a copy of meta with its times in UTC, see utcNull
+func (meta *Metadata) utc() *Metadata {
+	c := *meta
+	c.CreateDate = c.CreateDate.UTC()
+	c.ExpireDate = utcNull(c.ExpireDate)
+	c.DeleteDate = utcNull(c.DeleteDate)
+	c.ModifyDate = utcNull(c.ModifyDate)
+	return &c
+}
+
 // TODO should be using context for all queries
 
 // TODO How to not require an init to do this
-func Connect(ctx context.Context, dataSourceName string) (*DB, error) {
+// Connect connects to a Postgres or CockroachDB database
+func Connect(ctx context.Context, dataSourceName string) (*SQLDB, error) {
 	db, err := sqlx.Connect("postgres", dataSourceName)
 	if err != nil {
 		return nil, err
@@ -25,54 +125,166 @@ func Connect(ctx context.Context, dataSourceName string) (*DB, error) {
 	if err = db.PingContext(ctx); err != nil {
 		return nil, err
 	}
-	return &DB{db}, nil
+	return &SQLDB{db}, nil
 }
 
-func (db *DB) InsertMetadata(meta *Metadata) error {
+func (db *SQLDB) InsertMetadata(meta *Metadata) error {
 	if db == nil {
 		return errors.New("no db connected")
 	}
 	q := "INSERT INTO gob_metadata (" +
-		"id, secret, encrypted, create_date, " +
-		"expire_date, size, owner_id, content_type, filename)" +
+		"id, secret_hash, encrypted, create_date, " +
+		"expire_date, size, owner_id, content_type, filename, views_left, content_hash, horde, uploading)" +
 		"VALUES(" +
-		":id, :secret, :encrypted, :create_date, " +
-		":expire_date, :size, :owner_id, :content_type, :filename)"
-	_, err := db.NamedExec(q, meta)
+		":id, :secret_hash, :encrypted, :create_date, " +
+		":expire_date, :size, :owner_id, :content_type, :filename, :views_left, :content_hash, :horde, :uploading)"
+	_, err := db.NamedExec(q, meta.utc())
+	if IsUniqueViolation(err) {
+		return ErrConflict
+	}
 	return err
 }
 
-func (db *DB) GetMetadataByID(id string) (*Metadata, error) {
+func (db *SQLDB) GetMetadataByID(id string) (*Metadata, error) {
 	if db == nil {
 		return nil, errors.New("no db connected")
 	}
-	meta := NewMetadata()
+	meta := &Metadata{}
 	// TODO: should select specify the coloumns
 	err := db.QueryRowx("SELECT * FROM gob_metadata WHERE id=$1", id).StructScan(meta)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 	return meta, nil
 }
 
-func (db *DB) GetMetadataBySecret(secret string) (*Metadata, error) {
+func (db *SQLDB) GetMetadataBySecret(secret string) (*Metadata, error) {
 	if db == nil {
 		return nil, errors.New("no db connected")
 	}
-	meta := NewMetadata()
+	meta := &Metadata{}
 	// TODO: should select specify the coloumns
-	err := db.QueryRowx("SELECT * FROM gob_metadata WHERE secret=$1", secret).StructScan(meta)
+	err := db.QueryRowx("SELECT * FROM gob_metadata WHERE secret_hash=$1", HashSecret(secret)).StructScan(meta)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 	return meta, nil
 }
 
-func (db *DB) DeleteMetadataBySecret(secret string) error {
+func (db *SQLDB) DeleteMetadataBySecret(secret string) error {
+	if db == nil {
+		return errors.New("no db connected")
+	}
+	result, err := db.Exec("DELETE FROM gob_metadata WHERE secret_hash=$1", HashSecret(secret))
+	if err != nil {
+		return err
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows != 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (db *SQLDB) DeleteMetadataByID(id string) error {
+	if db == nil {
+		return errors.New("no db connected")
+	}
+	result, err := db.Exec("DELETE FROM gob_metadata WHERE id=$1", id)
+	if err != nil {
+		return err
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows != 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (db *SQLDB) MarkMetadataDeleted(id string, t time.Time) error {
+	if db == nil {
+		return errors.New("no db connected")
+	}
+	q := "UPDATE gob_metadata SET delete_date=$1, expire_date=$1 WHERE id=$2 AND delete_date IS NULL"
+	result, err := db.Exec(q, t.UTC(), id)
+	if err != nil {
+		return err
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows != 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (db *SQLDB) DeleteTombstones(t time.Time) (int64, error) {
+	if db == nil {
+		return 0, errors.New("no db connected")
+	}
+	result, err := db.Exec("DELETE FROM gob_metadata WHERE delete_date < $1", t.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *SQLDB) GetExpiredMetadata(t time.Time, limit int) ([]*Metadata, error) {
+	if db == nil {
+		return nil, errors.New("no db connected")
+	}
+	metas := []*Metadata{}
+	q := "SELECT * FROM gob_metadata WHERE (expire_date < $1 OR views_left <= 0) AND delete_date IS NULL " +
+		"ORDER BY expire_date LIMIT $2"
+	if err := db.Select(&metas, q, t.UTC(), limit); err != nil {
+		return nil, err
+	}
+	return metas, nil
+}
+
+func (db *SQLDB) DecrementViewsLeft(id string) (int64, error) {
+	if db == nil {
+		return 0, errors.New("no db connected")
+	}
+	// Not UPDATE ... RETURNING, SQLite only has it since 3.35. The update
+	// holds the row until commit, so the select sees what it left.
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	q := "UPDATE gob_metadata SET views_left = views_left - 1 WHERE id=$1 AND views_left > 0"
+	result, err := tx.Exec(q, id)
+	if err != nil {
+		return 0, err
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if numRows != 1 {
+		return 0, ErrNotFound
+	}
+	var viewsLeft int64
+	if err := tx.QueryRowx("SELECT views_left FROM gob_metadata WHERE id=$1", id).Scan(&viewsLeft); err != nil {
+		return 0, err
+	}
+	return viewsLeft, tx.Commit()
+}
+
+func (db *SQLDB) IncrementViewsLeft(id string) error {
 	if db == nil {
 		return errors.New("no db connected")
 	}
-	result, err := db.Exec("DELETE FROM gob_metadata WHERE secret=$1", secret)
+	result, err := db.Exec("UPDATE gob_metadata SET views_left = views_left + 1 WHERE id=$1 AND views_left IS NOT NULL", id)
 	if err != nil {
 		return err
 	}
@@ -81,22 +293,84 @@ func (db *DB) DeleteMetadataBySecret(secret string) error {
 		return err
 	}
 	if numRows != 1 {
-		return errors.New("failed to delete metatdata")
+		return ErrNotFound
 	}
 	return nil
 }
 
-func (db *DB) UpdateMetadata(meta *Metadata) error {
+func (db *SQLDB) UpdateContent(meta *Metadata, oldSize int64, oldParts string) error {
+	if db == nil {
+		return errors.New("no db connected")
+	}
+	now := time.Now()
+	q := "UPDATE gob_metadata SET size=$1, parts=$2, content_hash=$3, hash_state=$4, modify_date=$5, uploading=$6 " +
+		"WHERE id=$7 AND size=$8 AND parts=$9 AND delete_date IS NULL AND (uploading OR " +
+		"((expire_date IS NULL OR expire_date > $10) AND (views_left IS NULL OR views_left > 0)))"
+	result, err := db.Exec(q, meta.Size, meta.Parts, meta.ContentHash, meta.HashState, utcNull(meta.ModifyDate), false,
+		meta.ID, oldSize, oldParts, now.UTC())
+	if err != nil {
+		return err
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows != 1 {
+		current, err := db.GetMetadataByID(meta.ID)
+		if err != nil {
+			return err
+		}
+		if current.Deleted() || (!current.Uploading && current.Expired(now)) {
+			return ErrNotFound
+		}
+		return ErrConflict
+	}
+	meta.Uploading = false
+	return nil
+}
+
+func (db *SQLDB) TouchUpload(id string, t time.Time) error {
+	if db == nil {
+		return errors.New("no db connected")
+	}
+	result, err := db.Exec("UPDATE gob_metadata SET modify_date=$1 WHERE id=$2 AND uploading AND delete_date IS NULL", t.UTC(), id)
+	if err != nil {
+		return err
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numRows != 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (db *SQLDB) GetStaleUploads(t time.Time, limit int) ([]*Metadata, error) {
+	if db == nil {
+		return nil, errors.New("no db connected")
+	}
+	metas := []*Metadata{}
+	q := "SELECT * FROM gob_metadata WHERE uploading AND COALESCE(modify_date, create_date) < $1 " +
+		"AND delete_date IS NULL ORDER BY create_date LIMIT $2"
+	if err := db.Select(&metas, q, t.UTC(), limit); err != nil {
+		return nil, err
+	}
+	return metas, nil
+}
+
+func (db *SQLDB) UpdateMetadata(meta *Metadata) error {
 	if db == nil {
 		return errors.New("no db connected")
 	}
 	q := "UPDATE gob_metadata SET (" +
 		"encrypted, create_date, expire_date, " +
-		"size, owner_id, content_type, filename) = (" +
+		"owner_id, content_type, filename, views_left, horde) = (" +
 		":encrypted, :create_date, :expire_date, " +
-		":size, :owner_id, :content_type, :filename) " +
-		"WHERE id = :id"
-	result, err := db.NamedExec(q, meta)
+		":owner_id, :content_type, :filename, :views_left, :horde) " +
+		"WHERE id = :id AND delete_date IS NULL"
+	result, err := db.NamedExec(q, meta.utc())
 	if err != nil {
 		return err
 	}
@@ -105,7 +379,7 @@ func (db *DB) UpdateMetadata(meta *Metadata) error {
 		return err
 	}
 	if numRows != 1 {
-		return errors.New("failed to update metatdata")
+		return ErrNotFound
 	}
 	return nil
 }
@@ -113,7 +387,7 @@ func (db *DB) UpdateMetadata(meta *Metadata) error {
 // NewInsertedMetadata returns new *Metadata that has been successfully inserted into db
 // TODO create an entirely new struct each time not efficient
 // TODO atleast unset old struct?
-func (db *DB) NewInsertedMetadata(tries int) (*Metadata, error) {
+func NewInsertedMetadata(db DB, tries int) (*Metadata, error) {
 	var meta *Metadata
 	for i := 0; i < tries; i++ {
 		meta = NewMetadata()
@@ -130,6 +404,9 @@ func (db *DB) NewInsertedMetadata(tries int) (*Metadata, error) {
 }
 
 func IsUniqueViolation(err error) bool {
+	if err == ErrConflict || isSQLiteUniqueViolation(err) {
+		return true
+	}
 	if err, ok := err.(*pq.Error); ok {
 		if err.Code.Name() == "unique_violation" {
 			return true
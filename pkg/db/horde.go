@@ -0,0 +1,133 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Horde is a named collection of gobs. Anyone can upload into a horde, its
+// secret is only given to whoever created it and expires the whole horde.
+type Horde struct {
+	Name string `db:"name"`
+	// Secret is only set on a new horde, like Metadata's
+	Secret     string    `db:"-"`
+	SecretHash string    `db:"secret_hash"`
+	CreateDate time.Time `db:"create_date"`
+	// ExpireDate is when the horde and every gob in it expire, NULL is never
+	ExpireDate sql.NullTime `db:"expire_date"`
+}
+
+// NewHorde returns a new *Horde called name
+func NewHorde(name string) *Horde {
+	secret := randomReadableString(SecretLen)
+	return &Horde{
+		Name:       name,
+		Secret:     secret,
+		SecretHash: HashSecret(secret),
+		CreateDate: time.Now(),
+	}
+}
+
+func (h *Horde) SetExpireDate(t time.Time) {
+	h.ExpireDate = sql.NullTime{
+		Time:  t,
+		Valid: true,
+	}
+}
+
+// Expired is whether the horde has passed its expire date at t
+func (h *Horde) Expired(t time.Time) bool {
+	return h.ExpireDate.Valid && t.After(h.ExpireDate.Time)
+}
+
+func (db *SQLDB) InsertHorde(horde *Horde) error {
+	if db == nil {
+		return errors.New("no db connected")
+	}
+	q := "INSERT INTO horde (name, secret_hash, create_date, expire_date) " +
+		"VALUES (:name, :secret_hash, :create_date, :expire_date)"
+	utc := *horde
+	utc.CreateDate = utc.CreateDate.UTC()
+	utc.ExpireDate = utcNull(utc.ExpireDate)
+	_, err := db.NamedExec(q, &utc)
+	if IsUniqueViolation(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+func (db *SQLDB) GetHorde(name string) (*Horde, error) {
+	if db == nil {
+		return nil, errors.New("no db connected")
+	}
+	horde := &Horde{}
+	err := db.QueryRowx("SELECT * FROM horde WHERE name=$1", name).StructScan(horde)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return horde, nil
+}
+
+func (db *SQLDB) GetHordeBySecret(secret string) (*Horde, error) {
+	if db == nil {
+		return nil, errors.New("no db connected")
+	}
+	horde := &Horde{}
+	err := db.QueryRowx("SELECT * FROM horde WHERE secret_hash=$1", HashSecret(secret)).StructScan(horde)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return horde, nil
+}
+
+func (db *SQLDB) GetHordeMetadata(name string) ([]*Metadata, error) {
+	if db == nil {
+		return nil, errors.New("no db connected")
+	}
+	metas := []*Metadata{}
+	q := "SELECT * FROM gob_metadata WHERE horde=$1 ORDER BY create_date"
+	if err := db.Select(&metas, q, name); err != nil {
+		return nil, err
+	}
+	return metas, nil
+}
+
+func (db *SQLDB) ExpireHorde(name string, t time.Time) error {
+	if db == nil {
+		return errors.New("no db connected")
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec("UPDATE horde SET expire_date=$1 WHERE name=$2", t.UTC(), name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if numRows, err := result.RowsAffected(); err != nil {
+		tx.Rollback()
+		return err
+	} else if numRows != 1 {
+		tx.Rollback()
+		return ErrNotFound
+	}
+	q := "UPDATE gob_metadata SET expire_date=$1 WHERE horde=$2 AND (expire_date IS NULL OR expire_date > $1)"
+	if _, err := tx.Exec(q, t.UTC(), name); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *SQLDB) DeleteExpiredHordes(t time.Time) (int64, error) {
+	if db == nil {
+		return 0, errors.New("no db connected")
+	}
+	result, err := db.Exec("DELETE FROM horde WHERE expire_date < $1", t.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,330 @@
+package db
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryDB is a DB that keeps metadata in memory. It's meant for tests and
+// trying gobin out, everything is lost when the process exits.
+type MemoryDB struct {
+	mu   sync.RWMutex
+	byID map[string]*Metadata
+	// bySecret and hordeBySecret are keyed by secret hash
+	bySecret      map[string]*Metadata
+	hordes        map[string]*Horde
+	hordeBySecret map[string]*Horde
+}
+
+// NewMemoryDB returns an empty *MemoryDB
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		byID:          map[string]*Metadata{},
+		bySecret:      map[string]*Metadata{},
+		hordes:        map[string]*Horde{},
+		hordeBySecret: map[string]*Horde{},
+	}
+}
+
+// copyMetadata is used so callers never share a *Metadata with the db. Like
+// SQLDB, the secret itself isn't kept.
+func copyMetadata(meta *Metadata) *Metadata {
+	c := *meta
+	c.Secret = ""
+	return &c
+}
+
+func (db *MemoryDB) InsertMetadata(meta *Metadata) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.byID[meta.ID]; ok {
+		return ErrConflict
+	}
+	if _, ok := db.bySecret[meta.SecretHash]; ok {
+		return ErrConflict
+	}
+	meta = copyMetadata(meta)
+	db.byID[meta.ID] = meta
+	db.bySecret[meta.SecretHash] = meta
+	return nil
+}
+
+func (db *MemoryDB) GetMetadataByID(id string) (*Metadata, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	meta, ok := db.byID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyMetadata(meta), nil
+}
+
+func (db *MemoryDB) GetMetadataBySecret(secret string) (*Metadata, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	meta, ok := db.bySecret[HashSecret(secret)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyMetadata(meta), nil
+}
+
+func (db *MemoryDB) DeleteMetadataBySecret(secret string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	meta, ok := db.bySecret[HashSecret(secret)]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(db.bySecret, meta.SecretHash)
+	delete(db.byID, meta.ID)
+	return nil
+}
+
+func (db *MemoryDB) DeleteMetadataByID(id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	meta, ok := db.byID[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(db.bySecret, meta.SecretHash)
+	delete(db.byID, id)
+	return nil
+}
+
+func (db *MemoryDB) MarkMetadataDeleted(id string, t time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	meta, ok := db.byID[id]
+	if !ok || meta.Deleted() {
+		return ErrNotFound
+	}
+	meta.DeleteDate = sql.NullTime{Time: t, Valid: true}
+	meta.SetExpireDate(t)
+	return nil
+}
+
+func (db *MemoryDB) DeleteTombstones(t time.Time) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var n int64
+	for id, meta := range db.byID {
+		if meta.Deleted() && meta.DeleteDate.Time.Before(t) {
+			delete(db.bySecret, meta.SecretHash)
+			delete(db.byID, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (db *MemoryDB) GetExpiredMetadata(t time.Time, limit int) ([]*Metadata, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	metas := []*Metadata{}
+	for _, meta := range db.byID {
+		if meta.Expired(t) && !meta.Deleted() {
+			metas = append(metas, copyMetadata(meta))
+		}
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].ExpireDate.Time.Before(metas[j].ExpireDate.Time)
+	})
+	if len(metas) > limit {
+		metas = metas[:limit]
+	}
+	return metas, nil
+}
+
+func (db *MemoryDB) DecrementViewsLeft(id string) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	meta, ok := db.byID[id]
+	if !ok || !meta.ViewsLeft.Valid || meta.ViewsLeft.Int64 <= 0 {
+		return 0, ErrNotFound
+	}
+	meta.ViewsLeft.Int64--
+	return meta.ViewsLeft.Int64, nil
+}
+
+func (db *MemoryDB) IncrementViewsLeft(id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	meta, ok := db.byID[id]
+	if !ok || !meta.ViewsLeft.Valid {
+		return ErrNotFound
+	}
+	meta.ViewsLeft.Int64++
+	return nil
+}
+
+func (db *MemoryDB) UpdateContent(meta *Metadata, oldSize int64, oldParts string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	current, ok := db.byID[meta.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if current.Deleted() || (!current.Uploading && current.Expired(time.Now())) {
+		return ErrNotFound
+	}
+	if current.Size != oldSize || current.Parts != oldParts {
+		return ErrConflict
+	}
+	current.Size = meta.Size
+	current.Parts = meta.Parts
+	current.ContentHash = meta.ContentHash
+	current.HashState = meta.HashState
+	current.ModifyDate = meta.ModifyDate
+	current.Uploading = false
+	meta.Uploading = false
+	return nil
+}
+
+func (db *MemoryDB) TouchUpload(id string, t time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	meta, ok := db.byID[id]
+	if !ok || !meta.Uploading || meta.Deleted() {
+		return ErrNotFound
+	}
+	meta.ModifyDate = sql.NullTime{Time: t, Valid: true}
+	return nil
+}
+
+func (db *MemoryDB) GetStaleUploads(t time.Time, limit int) ([]*Metadata, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	metas := []*Metadata{}
+	for _, meta := range db.byID {
+		touched := meta.CreateDate
+		if meta.ModifyDate.Valid {
+			touched = meta.ModifyDate.Time
+		}
+		if meta.Uploading && touched.Before(t) && !meta.Deleted() {
+			metas = append(metas, copyMetadata(meta))
+		}
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].CreateDate.Before(metas[j].CreateDate)
+	})
+	if len(metas) > limit {
+		metas = metas[:limit]
+	}
+	return metas, nil
+}
+
+// UpdateMetadata updates everything but the secret and content, same as
+// SQLDB
+func (db *MemoryDB) UpdateMetadata(meta *Metadata) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	old, ok := db.byID[meta.ID]
+	if !ok || old.Deleted() {
+		return ErrNotFound
+	}
+	meta = copyMetadata(meta)
+	meta.SecretHash = old.SecretHash
+	meta.DeleteDate = old.DeleteDate
+	meta.Size = old.Size
+	meta.Parts = old.Parts
+	meta.ContentHash = old.ContentHash
+	meta.HashState = old.HashState
+	meta.ModifyDate = old.ModifyDate
+	meta.Uploading = old.Uploading
+	db.byID[meta.ID] = meta
+	db.bySecret[meta.SecretHash] = meta
+	return nil
+}
+
+func copyHorde(horde *Horde) *Horde {
+	c := *horde
+	c.Secret = ""
+	return &c
+}
+
+func (db *MemoryDB) InsertHorde(horde *Horde) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.hordes[horde.Name]; ok {
+		return ErrConflict
+	}
+	if _, ok := db.hordeBySecret[horde.SecretHash]; ok {
+		return ErrConflict
+	}
+	horde = copyHorde(horde)
+	db.hordes[horde.Name] = horde
+	db.hordeBySecret[horde.SecretHash] = horde
+	return nil
+}
+
+func (db *MemoryDB) GetHorde(name string) (*Horde, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	horde, ok := db.hordes[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyHorde(horde), nil
+}
+
+func (db *MemoryDB) GetHordeBySecret(secret string) (*Horde, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	horde, ok := db.hordeBySecret[HashSecret(secret)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyHorde(horde), nil
+}
+
+func (db *MemoryDB) GetHordeMetadata(name string) ([]*Metadata, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	metas := []*Metadata{}
+	for _, meta := range db.byID {
+		if meta.Horde.Valid && meta.Horde.String == name {
+			metas = append(metas, copyMetadata(meta))
+		}
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].CreateDate.Before(metas[j].CreateDate)
+	})
+	return metas, nil
+}
+
+func (db *MemoryDB) ExpireHorde(name string, t time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	horde, ok := db.hordes[name]
+	if !ok {
+		return ErrNotFound
+	}
+	horde.SetExpireDate(t)
+	for _, meta := range db.byID {
+		if !meta.Horde.Valid || meta.Horde.String != name {
+			continue
+		}
+		if !meta.ExpireDate.Valid || meta.ExpireDate.Time.After(t) {
+			meta.SetExpireDate(t)
+		}
+	}
+	return nil
+}
+
+func (db *MemoryDB) DeleteExpiredHordes(t time.Time) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var n int64
+	for name, horde := range db.hordes {
+		if horde.Expired(t) {
+			delete(db.hordeBySecret, horde.SecretHash)
+			delete(db.hordes, name)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -1,26 +1,56 @@
 package db
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
-	"math/rand"
+	"encoding/hex"
 	"net/http"
+	"strings"
 	"time"
-
-	"github.com/lib/pq"
 )
 
 // Metadata for a gob
 // TODO I don't really want ID, Secret, and ContentType to be exported, but then I can't use them in sqlx
 type Metadata struct {
-	ID          string         `db:"id"`
-	Secret      string         `db:"secret"`
+	ID string `db:"id"`
+	// Secret is only set on new metadata, so it can be given to the uploader.
+	// Only SecretHash is stored.
+	Secret      string         `db:"-"`
+	SecretHash  string         `db:"secret_hash"`
 	Encrypted   bool           `db:"encrypted"`
 	CreateDate  time.Time      `db:"create_date"`
-	ExpireDate  pq.NullTime    `db:"expire_date"`
+	ExpireDate  sql.NullTime   `db:"expire_date"`
 	Size        int64          `db:"size"`
 	OwnerID     int            `db:"owner_id"`
 	ContentType string         `db:"content_type"`
 	Filename    sql.NullString `db:"filename"`
+	// ViewsLeft is how many more times the gob can be downloaded, NULL is
+	// unlimited
+	ViewsLeft sql.NullInt64 `db:"views_left"`
+	// ContentHash is the hex SHA-256 of the gob as uploaded, NULL for gobs
+	// uploaded before it was kept
+	ContentHash sql.NullString `db:"content_hash"`
+	// Horde is the name of the horde the gob is in, NULL if none
+	Horde sql.NullString `db:"horde"`
+	// DeleteDate is when the gob was deleted. Deleted gobs are kept as
+	// tombstones for a while, so they can be told apart from ones that
+	// never existed.
+	DeleteDate sql.NullTime `db:"delete_date"`
+	// Parts are the names of the store objects appended to the gob, space
+	// separated in the order they're read after the one it was uploaded to
+	Parts string `db:"parts"`
+	// HashState is the base64 state of the SHA-256 of the gob so far, so
+	// appends can carry ContentHash on without reading the gob again. NULL
+	// for gobs uploaded before it was kept.
+	HashState sql.NullString `db:"hash_state"`
+	// ModifyDate is when the gob was last appended to, NULL if it never was.
+	// While it's uploading it's when the upload last said it's still going.
+	ModifyDate sql.NullTime `db:"modify_date"`
+	// Uploading is whether the gob's content is still being uploaded. It's
+	// set on new metadata and cleared by UpdateContent.
+	Uploading bool `db:"uploading"`
 }
 
 const (
@@ -32,19 +62,40 @@ const (
 	LegibleAlphanumeric = "ABCDEFGHIJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
 )
 
-var legibleRunes = []rune(LegibleAlphanumeric)
-
-// TODO does this create a uniform distrobution?
-// TODO set seed?
-// TODO where should this go?
+// randomReadableString returns n characters of LegibleAlphanumeric read from
+// crypto/rand. Bytes past the last whole multiple of its length are rejected,
+// so every character is equally likely.
 func randomReadableString(n int) string {
-	b := make([]rune, n)
-	for i := range b {
-		b[i] = legibleRunes[rand.Intn(len(legibleRunes))]
+	limit := 256 - 256%len(LegibleAlphanumeric)
+	b := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(b) < n {
+		if _, err := rand.Read(buf); err != nil {
+			// Only if the OS's randomness is broken, nothing to do but stop
+			panic(err)
+		}
+		for _, c := range buf {
+			if int(c) < limit && len(b) < n {
+				b = append(b, LegibleAlphanumeric[int(c)%len(LegibleAlphanumeric)])
+			}
+		}
 	}
 	return string(b)
 }
 
+// HashSecret returns the hex SHA-256 of secret, which is what's stored.
+// Secrets are random, so they don't need a salted or slow hash.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// SecretMatches is whether secret hashes to hash, in constant time so the
+// hash can't be guessed a byte at a time
+func SecretMatches(hash, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(HashSecret(secret))) == 1
+}
+
 // NewMetadata returns new *Metadata instance
 func NewMetadata() *Metadata {
 	id := randomReadableString(IDLen)
@@ -52,7 +103,9 @@ func NewMetadata() *Metadata {
 	return &Metadata{
 		ID:         id,
 		Secret:     secret,
+		SecretHash: HashSecret(secret),
 		CreateDate: time.Now(),
+		Uploading:  true,
 	}
 }
 
@@ -64,12 +117,57 @@ func (g *Metadata) SetContentType(data []byte) string {
 }
 
 func (g *Metadata) SetExpireDate(t time.Time) {
-	g.ExpireDate = pq.NullTime{
+	g.ExpireDate = sql.NullTime{
 		Time:  t,
 		Valid: true,
 	}
 }
 
+// SetViewsLeft limits how many more times the gob can be downloaded, n <= 0
+// is unlimited
+func (g *Metadata) SetViewsLeft(n int64) {
+	if n <= 0 {
+		g.ViewsLeft = sql.NullInt64{}
+		return
+	}
+	g.ViewsLeft = sql.NullInt64{
+		Int64: n,
+		Valid: true,
+	}
+}
+
+// Expired is whether the gob has passed its expire date or has no views left
+// at t
+func (g *Metadata) Expired(t time.Time) bool {
+	if g.ExpireDate.Valid && t.After(g.ExpireDate.Time) {
+		return true
+	}
+	return g.ViewsLeft.Valid && g.ViewsLeft.Int64 <= 0
+}
+
+// Deleted is whether the gob was deleted and this is its tombstone
+func (g *Metadata) Deleted() bool {
+	return g.DeleteDate.Valid
+}
+
+// PartList returns the Parts in order
+func (g *Metadata) PartList() []string {
+	return strings.Fields(g.Parts)
+}
+
+// AddPart puts the store object called part at the end of the gob
+func (g *Metadata) AddPart(part string) {
+	g.Parts = strings.TrimSpace(g.Parts + " " + part)
+}
+
+// LastModified is when the content of the gob last changed
+func (g *Metadata) LastModified() time.Time {
+	if g.ModifyDate.Valid {
+		return g.ModifyDate.Time
+	}
+	return g.CreateDate
+}
+
 func (g *Metadata) SetFilename(filename string) {
 	if filename == "" {
 		return
@@ -79,3 +177,13 @@ func (g *Metadata) SetFilename(filename string) {
 		Valid:  true,
 	}
 }
+
+func (g *Metadata) SetHorde(name string) {
+	if name == "" {
+		return
+	}
+	g.Horde = sql.NullString{
+		String: name,
+		Valid:  true,
+	}
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/levenlabs/errctx"
+)
+
+// Migration is a versioned change to the schema. Up and Down hold the SQL for
+// each sqlx driver name, e.g. "postgres" and "sqlite3".
+type Migration struct {
+	Version     int
+	Description string
+	Up          map[string]string
+	Down        map[string]string
+	// Func, if set, runs after the Up sql in the same transaction, for data
+	// changes that can't be written in every dialect's SQL. Up and Down are
+	// optional for migrations with one.
+	Func func(ctx context.Context, tx *sqlx.Tx) error
+}
+
+// Migrator is a DB with a versioned schema
+type Migrator interface {
+	// SchemaVersion returns the version of the last migration applied, 0 if
+	// none have been
+	SchemaVersion(ctx context.Context) (int, error)
+	// Migrate applies or reverts migrations until the schema is at version
+	Migrate(ctx context.Context, version int) error
+}
+
+// LatestSchemaVersion is the version of the newest migration
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+const createSchemaVersionTable = `
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version      INTEGER PRIMARY KEY,
+		applied_date TIMESTAMP NOT NULL
+	)`
+
+func (db *SQLDB) SchemaVersion(ctx context.Context) (int, error) {
+	if _, err := db.ExecContext(ctx, createSchemaVersionTable); err != nil {
+		return 0, errctx.Mark(err)
+	}
+	var version int
+	err := db.QueryRowxContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	if err != nil {
+		return 0, errctx.Mark(err)
+	}
+	return version, nil
+}
+
+func (db *SQLDB) Migrate(ctx context.Context, version int) error {
+	if version < 0 || version > LatestSchemaVersion() {
+		return errctx.Mark(fmt.Errorf("no schema version %d, latest is %d", version, LatestSchemaVersion()))
+	}
+	current, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.Version > current && m.Version <= version {
+			if err := db.applyMigration(ctx, m, true); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= current && m.Version > version {
+			if err := db.applyMigration(ctx, m, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyMigration runs the up or down sql of m and records it in
+// schema_version in a single transaction
+func (db *SQLDB) applyMigration(ctx context.Context, m *Migration, up bool) error {
+	q, record := m.Down[db.DriverName()], "DELETE FROM schema_version WHERE version = ?"
+	if up {
+		q, record = m.Up[db.DriverName()], "INSERT INTO schema_version (version, applied_date) VALUES (?, ?)"
+	}
+	if q == "" && m.Func == nil {
+		return errctx.Mark(fmt.Errorf("migration %d has no %s sql", m.Version, db.DriverName()))
+	}
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errctx.Mark(err)
+	}
+	if q != "" {
+		if _, err := tx.ExecContext(ctx, q); err != nil {
+			tx.Rollback()
+			return errctx.Mark(fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Description, err))
+		}
+	}
+	if up && m.Func != nil {
+		if err := m.Func(ctx, tx); err != nil {
+			tx.Rollback()
+			return errctx.Mark(fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Description, err))
+		}
+	}
+	args := []interface{}{m.Version}
+	if up {
+		args = append(args, time.Now().UTC())
+	}
+	if _, err := tx.ExecContext(ctx, db.Rebind(record), args...); err != nil {
+		tx.Rollback()
+		return errctx.Mark(err)
+	}
+	return errctx.Mark(tx.Commit())
+}
@@ -0,0 +1,300 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// migrations of the SQL schema, in order. Never edit one that has been
+// released, add a new one instead.
+//
+// The postgres dialect is used for CockroachDB as well, so stick to types
+// and syntax both understand.
+var migrations = []*Migration{
+	{
+		Version:     1,
+		Description: "create gob_metadata",
+		// IF NOT EXISTS so databases set up by hand with gobin.sql can be
+		// migrated
+		Up: map[string]string{
+			"postgres": `
+				CREATE TABLE IF NOT EXISTS gob_metadata (
+					id           TEXT PRIMARY KEY,
+					secret       TEXT UNIQUE NOT NULL,
+					encrypted    BOOLEAN,
+					create_date  TIMESTAMP,
+					expire_date  TIMESTAMP,
+					size         BIGINT,
+					filename     TEXT,
+					content_type TEXT,
+					owner_id     BIGINT
+				)`,
+			"sqlite3": `
+				CREATE TABLE IF NOT EXISTS gob_metadata (
+					id           TEXT PRIMARY KEY,
+					secret       TEXT UNIQUE NOT NULL,
+					encrypted    BOOLEAN,
+					create_date  TIMESTAMP,
+					expire_date  TIMESTAMP,
+					size         INTEGER,
+					filename     TEXT,
+					content_type TEXT,
+					owner_id     INTEGER
+				)`,
+		},
+		Down: map[string]string{
+			"postgres": `DROP TABLE gob_metadata`,
+			"sqlite3":  `DROP TABLE gob_metadata`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "index gob_metadata expire_date",
+		// For the reaper finding expired gobs
+		Up: map[string]string{
+			"postgres": `CREATE INDEX IF NOT EXISTS gob_metadata_expire_date_idx ON gob_metadata (expire_date)`,
+			"sqlite3":  `CREATE INDEX IF NOT EXISTS gob_metadata_expire_date_idx ON gob_metadata (expire_date)`,
+		},
+		Down: map[string]string{
+			"postgres": `DROP INDEX gob_metadata_expire_date_idx`,
+			"sqlite3":  `DROP INDEX gob_metadata_expire_date_idx`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "add gob_metadata views_left",
+		Up: map[string]string{
+			"postgres": `ALTER TABLE gob_metadata ADD COLUMN views_left BIGINT`,
+			"sqlite3":  `ALTER TABLE gob_metadata ADD COLUMN views_left INTEGER`,
+		},
+		Down: map[string]string{
+			"postgres": `ALTER TABLE gob_metadata DROP COLUMN views_left`,
+			"sqlite3":  sqliteRebuild("gob_metadata", sqliteGobMetadataV1, sqliteExpireDateIndex),
+		},
+	},
+	{
+		Version:     4,
+		Description: "add gob_metadata content_hash",
+		Up: map[string]string{
+			"postgres": `ALTER TABLE gob_metadata ADD COLUMN content_hash TEXT`,
+			"sqlite3":  `ALTER TABLE gob_metadata ADD COLUMN content_hash TEXT`,
+		},
+		Down: map[string]string{
+			"postgres": `ALTER TABLE gob_metadata DROP COLUMN content_hash`,
+			"sqlite3": sqliteRebuild("gob_metadata", sqliteGobMetadataV1+
+				", views_left INTEGER", sqliteExpireDateIndex),
+		},
+	},
+	{
+		Version:     5,
+		Description: "create horde",
+		Up: map[string]string{
+			"postgres": `
+				CREATE TABLE horde (
+					name        TEXT PRIMARY KEY,
+					secret      TEXT UNIQUE NOT NULL,
+					create_date TIMESTAMP,
+					expire_date TIMESTAMP
+				)`,
+			"sqlite3": `
+				CREATE TABLE horde (
+					name        TEXT PRIMARY KEY,
+					secret      TEXT UNIQUE NOT NULL,
+					create_date TIMESTAMP,
+					expire_date TIMESTAMP
+				)`,
+		},
+		Down: map[string]string{
+			"postgres": `DROP TABLE horde`,
+			"sqlite3":  `DROP TABLE horde`,
+		},
+	},
+	{
+		Version:     6,
+		Description: "add gob_metadata horde",
+		// Not a foreign key, expired hordes are reaped before their gobs
+		// might be
+		Up: map[string]string{
+			"postgres": `ALTER TABLE gob_metadata ADD COLUMN horde TEXT`,
+			"sqlite3":  `ALTER TABLE gob_metadata ADD COLUMN horde TEXT`,
+		},
+		Down: map[string]string{
+			"postgres": `ALTER TABLE gob_metadata DROP COLUMN horde`,
+			"sqlite3": sqliteRebuild("gob_metadata", sqliteGobMetadataV1+
+				", views_left INTEGER, content_hash TEXT", sqliteExpireDateIndex),
+		},
+	},
+	{
+		Version:     7,
+		Description: "index gob_metadata horde",
+		// For listing a horde
+		Up: map[string]string{
+			"postgres": `CREATE INDEX IF NOT EXISTS gob_metadata_horde_idx ON gob_metadata (horde)`,
+			"sqlite3":  `CREATE INDEX IF NOT EXISTS gob_metadata_horde_idx ON gob_metadata (horde)`,
+		},
+		Down: map[string]string{
+			"postgres": `DROP INDEX gob_metadata_horde_idx`,
+			"sqlite3":  `DROP INDEX gob_metadata_horde_idx`,
+		},
+	},
+	{
+		Version:     8,
+		Description: "rename gob_metadata secret to secret_hash",
+		Up: map[string]string{
+			"postgres": `ALTER TABLE gob_metadata RENAME COLUMN secret TO secret_hash`,
+			"sqlite3":  `ALTER TABLE gob_metadata RENAME COLUMN secret TO secret_hash`,
+		},
+		Down: map[string]string{
+			"postgres": `ALTER TABLE gob_metadata RENAME COLUMN secret_hash TO secret`,
+			"sqlite3":  `ALTER TABLE gob_metadata RENAME COLUMN secret_hash TO secret`,
+		},
+	},
+	{
+		Version:     9,
+		Description: "rename horde secret to secret_hash",
+		Up: map[string]string{
+			"postgres": `ALTER TABLE horde RENAME COLUMN secret TO secret_hash`,
+			"sqlite3":  `ALTER TABLE horde RENAME COLUMN secret TO secret_hash`,
+		},
+		Down: map[string]string{
+			"postgres": `ALTER TABLE horde RENAME COLUMN secret_hash TO secret`,
+			"sqlite3":  `ALTER TABLE horde RENAME COLUMN secret_hash TO secret`,
+		},
+	},
+	{
+		Version:     10,
+		Description: "hash secrets",
+		// Separate from the renames since CockroachDB won't write to a
+		// column renamed in the same transaction. Hashes can't be undone,
+		// so going back down leaves them hashed, and up again skips them.
+		Func: hashSecrets,
+	},
+	{
+		Version:     11,
+		Description: "add gob_metadata delete_date",
+		Up: map[string]string{
+			"postgres": `ALTER TABLE gob_metadata ADD COLUMN delete_date TIMESTAMP`,
+			"sqlite3":  `ALTER TABLE gob_metadata ADD COLUMN delete_date TIMESTAMP`,
+		},
+		Down: map[string]string{
+			"postgres": `ALTER TABLE gob_metadata DROP COLUMN delete_date`,
+			"sqlite3":  sqliteRebuild("gob_metadata", sqliteGobMetadataV10, sqliteExpireDateIndex, sqliteHordeIndex),
+		},
+	},
+	{
+		Version:     12,
+		Description: "add gob_metadata parts, hash_state and modify_date",
+		// For appending to gobs without rewriting them
+		Up: map[string]string{
+			"postgres": `ALTER TABLE gob_metadata ADD COLUMN parts TEXT NOT NULL DEFAULT '',
+				ADD COLUMN hash_state TEXT, ADD COLUMN modify_date TIMESTAMP`,
+			"sqlite3": `ALTER TABLE gob_metadata ADD COLUMN parts TEXT NOT NULL DEFAULT '';
+				ALTER TABLE gob_metadata ADD COLUMN hash_state TEXT;
+				ALTER TABLE gob_metadata ADD COLUMN modify_date TIMESTAMP`,
+		},
+		Down: map[string]string{
+			"postgres": `ALTER TABLE gob_metadata DROP COLUMN parts, DROP COLUMN hash_state, DROP COLUMN modify_date`,
+			"sqlite3": sqliteRebuild("gob_metadata", sqliteGobMetadataV10+", delete_date TIMESTAMP",
+				sqliteExpireDateIndex, sqliteHordeIndex),
+		},
+	},
+	{
+		Version:     13,
+		Description: "add gob_metadata uploading",
+		// So every instance knows a gob is still uploading, and the reaper
+		// can find uploads whose process died
+		Up: map[string]string{
+			"postgres": `ALTER TABLE gob_metadata ADD COLUMN uploading BOOLEAN NOT NULL DEFAULT FALSE`,
+			"sqlite3":  `ALTER TABLE gob_metadata ADD COLUMN uploading BOOLEAN NOT NULL DEFAULT 0`,
+		},
+		Down: map[string]string{
+			"postgres": `ALTER TABLE gob_metadata DROP COLUMN uploading`,
+			"sqlite3": sqliteRebuild("gob_metadata", sqliteGobMetadataV12,
+				sqliteExpireDateIndex, sqliteHordeIndex),
+		},
+	},
+	{
+		Version:     14,
+		Description: "add gob_metadata uploading index",
+		// Separate from adding the column since CockroachDB can't index a
+		// column added in the same transaction
+		Up: map[string]string{
+			"postgres": `CREATE INDEX IF NOT EXISTS gob_metadata_uploading_idx ON gob_metadata (create_date) WHERE uploading`,
+			"sqlite3":  `CREATE INDEX IF NOT EXISTS gob_metadata_uploading_idx ON gob_metadata (create_date) WHERE uploading`,
+		},
+		Down: map[string]string{
+			"postgres": `DROP INDEX gob_metadata_uploading_idx`,
+			"sqlite3":  `DROP INDEX gob_metadata_uploading_idx`,
+		},
+	},
+}
+
+// sqliteGobMetadataV1 are the sqlite3 gob_metadata columns of migration 1
+const sqliteGobMetadataV1 = "id TEXT PRIMARY KEY, secret TEXT UNIQUE NOT NULL, encrypted BOOLEAN, " +
+	"create_date TIMESTAMP, expire_date TIMESTAMP, size INTEGER, filename TEXT, " +
+	"content_type TEXT, owner_id INTEGER"
+
+// sqliteGobMetadataV10 are the sqlite3 gob_metadata columns as of migration 10
+const sqliteGobMetadataV10 = "id TEXT PRIMARY KEY, secret_hash TEXT UNIQUE NOT NULL, encrypted BOOLEAN, " +
+	"create_date TIMESTAMP, expire_date TIMESTAMP, size INTEGER, filename TEXT, " +
+	"content_type TEXT, owner_id INTEGER, views_left INTEGER, content_hash TEXT, horde TEXT"
+
+// sqliteGobMetadataV12 are the sqlite3 gob_metadata columns as of migration 12
+const sqliteGobMetadataV12 = sqliteGobMetadataV10 + ", delete_date TIMESTAMP, " +
+	"parts TEXT NOT NULL DEFAULT '', hash_state TEXT, modify_date TIMESTAMP"
+
+const (
+	sqliteExpireDateIndex = "CREATE INDEX gob_metadata_expire_date_idx ON gob_metadata (expire_date)"
+	sqliteHordeIndex      = "CREATE INDEX gob_metadata_horde_idx ON gob_metadata (horde)"
+)
+
+// sqliteRebuild is the SQL to drop columns from table in SQLite, which only
+// has ALTER TABLE DROP COLUMN since 3.35. The table is copied into a new one
+// with just columns, the comma separated definitions of the ones kept, and
+// indexes, which are dropped with the old table, are made again.
+func sqliteRebuild(table, columns string, indexes ...string) string {
+	var names []string
+	for _, column := range strings.Split(columns, ",") {
+		names = append(names, strings.Fields(column)[0])
+	}
+	q := fmt.Sprintf(`
+		CREATE TABLE %[1]s_rebuild (%[2]s);
+		INSERT INTO %[1]s_rebuild SELECT %[3]s FROM %[1]s;
+		DROP TABLE %[1]s;
+		ALTER TABLE %[1]s_rebuild RENAME TO %[1]s;`,
+		table, columns, strings.Join(names, ", "))
+	for _, index := range indexes {
+		q += "\n" + index + ";"
+	}
+	return q
+}
+
+// hashedSecretReg matches a HashSecret, which no secret from before they were
+// hashed does
+var hashedSecretReg = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// hashSecrets replaces the secrets stored before they were hashed with their
+// HashSecret. Ones already hashed, from going down past it and back up, are
+// left alone.
+func hashSecrets(ctx context.Context, tx *sqlx.Tx) error {
+	for _, table := range []string{"gob_metadata", "horde"} {
+		var secrets []string
+		if err := tx.SelectContext(ctx, &secrets, "SELECT secret_hash FROM "+table); err != nil {
+			return err
+		}
+		q := tx.Rebind("UPDATE " + table + " SET secret_hash = ? WHERE secret_hash = ?")
+		for _, secret := range secrets {
+			if hashedSecretReg.MatchString(secret) {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, q, HashSecret(secret), secret); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+// ConnectSQLite opens the SQLite database file at path, creating it if
+// needed. It lets a single node run without a database server. The schema is
+// created by Migrate.
+func ConnectSQLite(ctx context.Context, path string) (*SQLDB, error) {
+	// WAL and a busy timeout so readers don't fail while an upload writes.
+	// Times are read back as UTC, which is how SQLDB stores them.
+	dsn := "file:" + path + "?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=1&_loc=UTC"
+	db, err := sqlx.Connect("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.PingContext(ctx); err != nil {
+		return nil, err
+	}
+	return &SQLDB{db}, nil
+}
+
+func isSQLiteUniqueViolation(err error) bool {
+	if err, ok := err.(sqlite3.Error); ok {
+		return err.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			err.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestSQLite(t *testing.T) *SQLDB {
+	t.Helper()
+	db, err := ConnectSQLite(context.Background(), filepath.Join(t.TempDir(), "gobin.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(context.Background(), LatestSchemaVersion()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSQLiteMigrateDown(t *testing.T) {
+	db := newTestSQLite(t)
+	ctx := context.Background()
+	meta, err := NewInsertedMetadata(db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Step down one at a time, the gob has to survive every table rebuild
+	for v := LatestSchemaVersion() - 1; v >= 1; v-- {
+		if err := db.Migrate(ctx, v); err != nil {
+			t.Fatalf("down to %d: %v", v, err)
+		}
+		var count int
+		if err := db.QueryRowx("SELECT COUNT(*) FROM gob_metadata WHERE id=$1", meta.ID).Scan(&count); err != nil {
+			t.Fatalf("version %d: %v", v, err)
+		} else if count != 1 {
+			t.Fatalf("version %d lost the gob", v)
+		}
+	}
+	if err := db.Migrate(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(ctx, LatestSchemaVersion()); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.SchemaVersion(ctx); err != nil {
+		t.Fatal(err)
+	} else if v != LatestSchemaVersion() {
+		t.Fatalf("got version %d", v)
+	}
+
+	// Going back up past hashing the secrets doesn't hash them twice
+	meta, err = NewInsertedMetadata(db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	horde := NewHorde("logs")
+	if err := db.InsertHorde(horde); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(ctx, 9); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(ctx, LatestSchemaVersion()); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.GetMetadataBySecret(meta.Secret); err != nil {
+		t.Fatalf("secret doesn't work after going down and up: %v", err)
+	} else if got.ID != meta.ID {
+		t.Fatalf("got gob %s, want %s", got.ID, meta.ID)
+	}
+	if _, err := db.GetHordeBySecret(horde.Secret); err != nil {
+		t.Fatalf("horde secret doesn't work after going down and up: %v", err)
+	}
+}
+
+func TestSQLiteDecrementViewsLeft(t *testing.T) {
+	db := newTestSQLite(t)
+	meta, err := NewInsertedMetadata(db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DecrementViewsLeft(meta.ID); err != ErrNotFound {
+		t.Fatalf("got %v, want ErrNotFound for unlimited views", err)
+	}
+	meta.SetViewsLeft(2)
+	if err := db.UpdateMetadata(meta); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int64{1, 0} {
+		if left, err := db.DecrementViewsLeft(meta.ID); err != nil {
+			t.Fatal(err)
+		} else if left != want {
+			t.Fatalf("got %d views left, want %d", left, want)
+		}
+	}
+	if _, err := db.DecrementViewsLeft(meta.ID); err != ErrNotFound {
+		t.Fatalf("got %v, want ErrNotFound with no views left", err)
+	}
+	if err := db.IncrementViewsLeft(meta.ID); err != nil {
+		t.Fatal(err)
+	}
+	if left, err := db.DecrementViewsLeft(meta.ID); err != nil || left != 0 {
+		t.Fatalf("got %d %v after giving a view back", left, err)
+	}
+}
+
+func TestSQLiteUpdateContent(t *testing.T) {
+	db := newTestSQLite(t)
+	meta, err := NewInsertedMetadata(db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.Size = 5
+	meta.AddPart("a")
+	meta.ContentHash = sql.NullString{String: "hash", Valid: true}
+	meta.HashState = sql.NullString{String: "state", Valid: true}
+	meta.ModifyDate = sql.NullTime{Time: time.Now(), Valid: true}
+	if err := db.UpdateContent(meta, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.GetMetadataByID(meta.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if got.Size != 5 || got.Parts != "a" || got.HashState.String != "state" || !got.ModifyDate.Valid {
+		t.Fatalf("got %+v", got)
+	}
+	// Stale size or parts is someone else's change
+	if err := db.UpdateContent(meta, 0, ""); err != ErrConflict {
+		t.Fatalf("got %v, want ErrConflict", err)
+	}
+	if err := db.UpdateContent(meta, 5, "b"); err != ErrConflict {
+		t.Fatalf("got %v, want ErrConflict", err)
+	}
+	// UpdateMetadata leaves the content alone
+	stale := *meta
+	stale.Size, stale.Parts = 0, ""
+	stale.SetFilename("f")
+	if err := db.UpdateMetadata(&stale); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = db.GetMetadataByID(meta.ID); err != nil {
+		t.Fatal(err)
+	} else if got.Size != 5 || got.Parts != "a" {
+		t.Fatalf("UpdateMetadata changed the content: %+v", got)
+	}
+	if err := db.MarkMetadataDeleted(meta.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateContent(meta, 5, "a"); err != ErrNotFound {
+		t.Fatalf("got %v, want ErrNotFound once deleted", err)
+	}
+}
+
+func TestSQLiteUploading(t *testing.T) {
+	db := newTestSQLite(t)
+	meta, err := NewInsertedMetadata(db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := NewInsertedMetadata(db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Finished even though it expired while uploading
+	done.SetExpireDate(time.Now().Add(-time.Second))
+	if err := db.UpdateMetadata(done); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateContent(done, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.GetMetadataByID(done.ID); err != nil {
+		t.Fatal(err)
+	} else if got.Uploading || done.Uploading {
+		t.Fatal("UpdateContent left the gob uploading")
+	}
+	if err := db.TouchUpload(done.ID, time.Now()); err != ErrNotFound {
+		t.Fatalf("got %v, want ErrNotFound touching a finished upload", err)
+	}
+
+	stale, err := db.GetStaleUploads(time.Now().Add(time.Second), 10)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(stale) != 1 || stale[0].ID != meta.ID || !stale[0].Uploading {
+		t.Fatalf("got %+v, want only %s", stale, meta.ID)
+	}
+	if err := db.TouchUpload(meta.ID, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if stale, err = db.GetStaleUploads(time.Now().Add(time.Second), 10); err != nil {
+		t.Fatal(err)
+	} else if len(stale) != 0 {
+		t.Fatalf("got %+v after a touch", stale)
+	}
+}
+
+func TestSQLiteTimeZones(t *testing.T) {
+	db := newTestSQLite(t)
+	now := time.Now()
+	// As strings in their own zones these sort the wrong way round
+	east, west := time.FixedZone("east", 5*60*60), time.FixedZone("west", -7*60*60)
+	expired, err := NewInsertedMetadata(db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired.SetExpireDate(now.Add(-30 * time.Minute).In(east))
+	live, err := NewInsertedMetadata(db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live.SetExpireDate(now.Add(30 * time.Minute).In(west))
+	for _, meta := range []*Metadata{expired, live} {
+		if err := db.UpdateMetadata(meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	metas, err := db.GetExpiredMetadata(now, 10)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(metas) != 1 || metas[0].ID != expired.ID {
+		t.Fatalf("got %d expired, want only %s", len(metas), expired.ID)
+	}
+	if got, err := db.GetMetadataByID(live.ID); err != nil {
+		t.Fatal(err)
+	} else if !got.ExpireDate.Time.Equal(live.ExpireDate.Time) {
+		t.Fatalf("got expire date %v, want %v", got.ExpireDate.Time, live.ExpireDate.Time)
+	}
+
+	horde := NewHorde("logs")
+	if err := db.InsertHorde(horde); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ExpireHorde("logs", now.Add(-time.Minute).In(east)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.DeleteExpiredHordes(now.In(west)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("deleted %d hordes, want 1", n)
+	}
+}
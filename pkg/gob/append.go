@@ -0,0 +1,242 @@
+package gob
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/kinghrothgar/gobin/pkg/store"
+	"github.com/levenlabs/errctx"
+	"github.com/levenlabs/go-llog"
+)
+
+// appendLocks serializes appends to the same gob in this process, so they
+// don't fail each other's db.UpdateContent. Appends from other processes
+// still can, and the loser deletes its part.
+var appendLocks = &idLocks{locks: map[string]*idLock{}}
+
+type idLock struct {
+	sync.Mutex
+	refs int
+}
+
+type idLocks struct {
+	mu    sync.Mutex
+	locks map[string]*idLock
+}
+
+func (l *idLocks) lock(id string) {
+	l.mu.Lock()
+	lock, ok := l.locks[id]
+	if !ok {
+		lock = &idLock{}
+		l.locks[id] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+	lock.Lock()
+}
+
+func (l *idLocks) unlock(id string) {
+	l.mu.Lock()
+	lock := l.locks[id]
+	if lock.refs--; lock.refs == 0 {
+		delete(l.locks, id)
+	}
+	l.mu.Unlock()
+	lock.Unlock()
+}
+
+// partPath is the store path of the part of the gob with id
+func partPath(id, part string) string {
+	return id + "." + part
+}
+
+// newPartName returns a random name for an appended part, so parts written at
+// the same time by different processes never overwrite each other
+func newPartName() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// Only if the OS's randomness is broken, nothing to do but stop
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// contentHash returns the hex of what h has hashed, and its state to carry
+// on from with resumeHash
+func contentHash(h hash.Hash) (sql.NullString, sql.NullString) {
+	sum := sql.NullString{String: hex.EncodeToString(h.Sum(nil)), Valid: true}
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return sum, sql.NullString{}
+	}
+	return sum, sql.NullString{String: base64.StdEncoding.EncodeToString(state), Valid: true}
+}
+
+// resumeHash returns a SHA-256 carried on from a state of contentHash
+func resumeHash(state string) (hash.Hash, error) {
+	b, err := base64.StdEncoding.DecodeString(state)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// objects returns the store objects of the gob of meta in the order they're
+// read, the one it was uploaded to and then its parts. encryptKey is needed if
+// it's encrypted.
+func (gob *Gob) objects(meta *db.Metadata, encryptKey string) ([]*store.Object, error) {
+	obj := store.NewObject(gob.store, meta.ID)
+	// TODO how to set salt?
+	if meta.Encrypted && encryptKey == "" {
+		return nil, ErrKeyRequired
+	} else if meta.Encrypted {
+		obj.Key(encryptKey, "saltsaltsalt")
+	}
+	return withParts(obj, meta), nil
+}
+
+// withParts returns obj, the object the gob of meta was uploaded to, followed
+// by its parts
+func withParts(obj *store.Object, meta *db.Metadata) []*store.Object {
+	objs := []*store.Object{obj}
+	for _, part := range meta.PartList() {
+		objs = append(objs, obj.WithPath(partPath(meta.ID, part)))
+	}
+	return objs
+}
+
+// deleteObjects deletes every store object of the gob of meta. It returns
+// whether the one it was uploaded to still existed, and the stored bytes of
+// the ones that did.
+func (gob *Gob) deleteObjects(meta *db.Metadata) (bool, int64, error) {
+	// Deleting doesn't need the key
+	objs := withParts(store.NewObject(gob.store, meta.ID), meta)
+	existed := false
+	var freed int64
+	for i, obj := range objs {
+		attrs, err := obj.Stat(gob.ctx)
+		if err == store.ErrNotFound {
+			continue
+		} else if err != nil {
+			return false, freed, &StoreError{meta.ID, err}
+		}
+		if err := obj.Delete(gob.ctx); err != nil {
+			return false, freed, &StoreError{meta.ID, err}
+		}
+		existed = existed || i == 0
+		freed += attrs.Size
+	}
+	return existed, freed, nil
+}
+
+// Append adds what's read from reader to the end of the gob with secret. It's
+// written to a part object of its own, so the gob isn't rewritten, and that's
+// added to the gob's parts only if no one else changed it meanwhile. maxSize
+// is the largest the gob can get, 0 is no limit. It returns the updated
+// metadata and how many bytes were appended.
+func (gob *Gob) Append(secret string, reader io.Reader, encryptKey string, maxSize int64) (*db.Metadata, int64, error) {
+	meta, err := gob.GetMetadataBySecret(secret)
+	if err != nil {
+		return nil, 0, err
+	}
+	appendLocks.lock(meta.ID)
+	defer appendLocks.unlock(meta.ID)
+	// It may have changed while waiting for the lock
+	if meta, err = gob.GetMetadataBySecret(secret); err != nil {
+		return nil, 0, err
+	}
+	objs, err := gob.objects(meta, encryptKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	if maxSize > 0 {
+		reader = &maxSizeReader{reader, maxSize - meta.Size}
+	}
+
+	var h hash.Hash
+	if meta.HashState.Valid {
+		if h, err = resumeHash(meta.HashState.String); err != nil {
+			return nil, 0, errctx.Mark(fmt.Errorf("invalid %s hash state: %v", meta.ID, err))
+		}
+	} else if h, err = gob.hashObjects(meta, objs); err != nil {
+		return nil, 0, err
+	}
+	if meta.Encrypted {
+		// The part would be encrypted with encryptKey, so it has to be the
+		// gob's. Opening it is enough to know.
+		r, err := objs[0].NewCompressedReader(gob.ctx)
+		if err != nil {
+			return nil, 0, storeError(meta.ID, err)
+		}
+		r.Close()
+	}
+
+	part := newPartName()
+	obj := objs[0].WithPath(partPath(meta.ID, part))
+	w, err := obj.NewWriter(gob.ctx)
+	if err != nil {
+		return nil, 0, storeError(meta.ID, err)
+	}
+	// Throws the part away if anything below fails, it does nothing once w
+	// is closed
+	defer w.Abort()
+	n, err := store.Copy(gob.ctx, io.MultiWriter(w, h), reader)
+	if errctx.Base(err) == ErrTooLarge {
+		return nil, 0, ErrTooLarge
+	} else if err != nil {
+		return nil, 0, errctx.Mark(err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, 0, &StoreError{meta.ID, err}
+	}
+
+	oldSize, oldParts := meta.Size, meta.Parts
+	meta.Size += n
+	meta.AddPart(part)
+	meta.ContentHash, meta.HashState = contentHash(h)
+	meta.ModifyDate = sql.NullTime{Time: time.Now(), Valid: true}
+	if err := gob.db.UpdateContent(meta, oldSize, oldParts); err != nil {
+		// The part is only the gob's if the update went through
+		if delErr := obj.Delete(gob.ctx); delErr != nil {
+			llog.Warn("failed to delete part of failed append", llog.KV{"id": meta.ID, "part": part}, llog.ErrKV(delErr))
+		}
+		switch errctx.Base(err) {
+		case db.ErrConflict:
+			return nil, 0, ErrAppendConflict
+		case db.ErrNotFound:
+			return nil, 0, ErrExpired
+		}
+		return nil, 0, errctx.Mark(fmt.Errorf("failed to update %s content: %v", meta.ID, err))
+	}
+	return meta, n, nil
+}
+
+// hashObjects returns a SHA-256 of the gob so far, for gobs uploaded before
+// their hash state was kept
+func (gob *Gob) hashObjects(meta *db.Metadata, objs []*store.Object) (hash.Hash, error) {
+	r, err := store.NewObjectsReader(gob.ctx, objs)
+	if err != nil {
+		return nil, storeError(meta.ID, err)
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := store.Copy(gob.ctx, h, r); err != nil {
+		return nil, errctx.Mark(fmt.Errorf("failed to hash %s: %v", meta.ID, err))
+	}
+	return h, nil
+}
@@ -0,0 +1,207 @@
+package gob
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/kinghrothgar/gobin/pkg/store"
+)
+
+// writtenBackend remembers the path of every object written
+type writtenBackend struct {
+	store.Backend
+	paths []string
+}
+
+func (b *writtenBackend) NewWriter(ctx context.Context, path string, key []byte) (store.ObjectWriter, error) {
+	b.paths = append(b.paths, path)
+	return b.Backend.NewWriter(ctx, path, key)
+}
+
+// racingDB runs race before the first UpdateContent, like an append from
+// another process would
+type racingDB struct {
+	db.DB
+	race func()
+}
+
+func (d *racingDB) UpdateContent(meta *db.Metadata, oldSize int64, oldParts string) error {
+	if race := d.race; race != nil {
+		d.race = nil
+		race()
+	}
+	return d.DB.UpdateContent(meta, oldSize, oldParts)
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func readObjectContent(t *testing.T, g *Gob, path string) string {
+	t.Helper()
+	r, err := store.NewObject(g.store, path).NewReader(g.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	buf := &strings.Builder{}
+	if _, err := store.Copy(g.ctx, buf, r); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestAppend(t *testing.T) {
+	g := newTestGob()
+	meta := upload(t, g, "hello", &UploadOptions{})
+	for _, s := range []string{" gobin", "", " world"} {
+		if _, _, err := g.Append(meta.Secret, strings.NewReader(s), "", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "hello gobin world"
+	if got, err := download(g, meta.ID, ""); err != nil {
+		t.Fatal(err)
+	} else if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	stored, err := g.GetMetadata(meta.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Size != int64(len(want)) {
+		t.Fatalf("got size %d", stored.Size)
+	} else if stored.ContentHash.String != sha256Hex(want) {
+		t.Fatal("content hash isn't of the whole gob")
+	} else if len(stored.PartList()) != 3 {
+		t.Fatalf("got parts %q", stored.Parts)
+	} else if !stored.ModifyDate.Valid || !stored.LastModified().Equal(stored.ModifyDate.Time) {
+		t.Fatalf("got modify date %v", stored.ModifyDate)
+	}
+	// Nothing was rewritten
+	if got := readObjectContent(t, g, meta.ID); got != "hello" {
+		t.Fatalf("uploaded object is now %q", got)
+	}
+
+	if _, _, err := g.Append(meta.Secret, strings.NewReader("too much"), "", int64(len(want))+4); err != ErrTooLarge {
+		t.Fatalf("got %v, want ErrTooLarge", err)
+	}
+}
+
+func TestAppendConflict(t *testing.T) {
+	backend := &writtenBackend{Backend: store.NewMemoryBackend()}
+	rdb := &racingDB{DB: db.NewMemoryDB()}
+	g := NewGob(context.Background(), rdb, backend)
+	meta := upload(t, g, "hello", &UploadOptions{})
+
+	// Another process appends " other" while this one writes its part
+	var loser string
+	rdb.race = func() {
+		loser = backend.paths[len(backend.paths)-1]
+		w, err := store.NewObject(backend, partPath(meta.ID, "other")).NewWriter(g.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(" other"))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		other := *meta
+		other.Size += 6
+		other.AddPart("other")
+		if err := rdb.DB.UpdateContent(&other, meta.Size, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := g.Append(meta.Secret, strings.NewReader(" mine"), "", 0); err != ErrAppendConflict {
+		t.Fatalf("got %v, want ErrAppendConflict", err)
+	}
+	// The winner's bytes are intact and the loser's part is gone
+	if got, err := download(g, meta.ID, ""); err != nil {
+		t.Fatal(err)
+	} else if got != "hello other" {
+		t.Fatalf("got %q", got)
+	}
+	if exists, err := store.NewObject(backend, loser).Exists(g.ctx); err != nil {
+		t.Fatal(err)
+	} else if exists {
+		t.Fatal("losing part was left in the store")
+	}
+}
+
+func TestAppendWithoutHashState(t *testing.T) {
+	g := newTestGob()
+	meta := upload(t, g, "hello", &UploadOptions{})
+	// Like a gob uploaded before the hash state was kept
+	meta.ContentHash, meta.HashState = sql.NullString{}, sql.NullString{}
+	if err := g.db.UpdateContent(meta, meta.Size, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := g.Append(meta.Secret, strings.NewReader(" gobin"), "", 0); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := g.GetMetadata(meta.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if stored.ContentHash.String != sha256Hex("hello gobin") || !stored.HashState.Valid {
+		t.Fatalf("got hash %v state %v", stored.ContentHash, stored.HashState)
+	}
+}
+
+func TestAppendEncrypted(t *testing.T) {
+	g := newTestGob()
+	meta := upload(t, g, "secret", &UploadOptions{EncryptKey: "k1"})
+	if _, _, err := g.Append(meta.Secret, strings.NewReader(" stuff"), "", 0); err != ErrKeyRequired {
+		t.Fatalf("got %v, want ErrKeyRequired", err)
+	}
+	if _, _, err := g.Append(meta.Secret, strings.NewReader(" stuff"), "k2", 0); err != ErrBadKey {
+		t.Fatalf("got %v, want ErrBadKey", err)
+	}
+	if _, _, err := g.Append(meta.Secret, strings.NewReader(" stuff"), "k1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := download(g, meta.ID, "k1"); err != nil {
+		t.Fatal(err)
+	} else if got != "secret stuff" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestDeleteAppended(t *testing.T) {
+	g := newTestGob()
+	for _, del := range []func(secret string) error{
+		g.Delete,
+		func(secret string) error {
+			if _, err := g.Expire(secret); err != nil {
+				return err
+			}
+			_, _, err := g.Reap(10)
+			return err
+		},
+	} {
+		secret := upload(t, g, "hello", &UploadOptions{}).Secret
+		meta, _, err := g.Append(secret, strings.NewReader(" gobin"), "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := del(secret); err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range withParts(store.NewObject(g.store, meta.ID), meta) {
+			if exists, err := obj.Exists(g.ctx); err != nil {
+				t.Fatal(err)
+			} else if exists {
+				t.Fatal("object left after delete")
+			}
+		}
+		if _, _, err := g.Append(secret, strings.NewReader("more"), "", 0); err == nil {
+			t.Fatal("appended to a deleted gob")
+		}
+	}
+}
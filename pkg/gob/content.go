@@ -0,0 +1,132 @@
+package gob
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/kinghrothgar/gobin/pkg/store"
+	"github.com/levenlabs/errctx"
+)
+
+// Content of a gob that can be read from any offset, e.g. for ranges.
+// Objects are stored compressed so they can't really seek: seeking backwards
+// reopens the object and seeking forwards reads and throws away what's
+// skipped.
+type Content struct {
+	gob  *Gob
+	objs []*store.Object
+	meta *db.Metadata
+	r    *store.Reader
+	// rPos is the offset r is at, pos is where the next Read is from
+	rPos int64
+	pos  int64
+	err  error
+}
+
+// OpenContent returns the Content of meta. Reading it doesn't take views, so
+// gobs with limited views can only be read with Download.
+func (gob *Gob) OpenContent(meta *db.Metadata, encryptKey string) (*Content, error) {
+	if meta.ViewsLeft.Valid {
+		return nil, errctx.Mark(errors.New("gob has limited views, use Download"))
+	}
+	if exists, err := store.NewObject(gob.store, meta.ID).Exists(gob.ctx); err != nil {
+		return nil, &StoreError{meta.ID, err}
+	} else if !exists {
+		return nil, ErrExpired
+	}
+	objs, err := gob.objects(meta, encryptKey)
+	if err != nil {
+		return nil, err
+	}
+	c := &Content{gob: gob, objs: objs, meta: meta}
+	// Opened now so a wrong key is found before anything is written
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// open (re)opens the objects at the start
+func (c *Content) open() error {
+	if c.r != nil {
+		c.r.Close()
+		c.r = nil
+	}
+	r, err := store.NewObjectsReader(c.gob.ctx, c.objs)
+	if err != nil {
+		return storeError(c.meta.ID, err)
+	}
+	c.r, c.rPos = r, 0
+	return nil
+}
+
+func (c *Content) Read(p []byte) (int, error) {
+	n, err := c.read(p)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+func (c *Content) read(p []byte) (int, error) {
+	if c.r == nil || c.pos < c.rPos {
+		if err := c.open(); err != nil {
+			return 0, err
+		}
+	}
+	if c.pos > c.rPos {
+		n, err := io.CopyN(ioutil.Discard, c.r, c.pos-c.rPos)
+		c.rPos += n
+		if err != nil {
+			return 0, c.readError(err)
+		}
+	}
+	n, err := c.r.Read(p)
+	c.rPos += int64(n)
+	c.pos += int64(n)
+	return n, c.readError(err)
+}
+
+func (c *Content) readError(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return errctx.Mark(fmt.Errorf("failed to read %s from store: %v", c.meta.ID, err))
+}
+
+// Seek sets where the next Read is from, relative to the size of the gob for
+// io.SeekEnd. It never fails for offsets past the end, the Read does.
+func (c *Content) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += c.pos
+	case io.SeekEnd:
+		offset += c.meta.Size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the gob")
+	}
+	c.pos = offset
+	return offset, nil
+}
+
+// Err returns the first error reading failed with, for callers like
+// http.ServeContent that don't return it
+func (c *Content) Err() error {
+	return c.err
+}
+
+func (c *Content) Close() error {
+	if c.r == nil {
+		return nil
+	}
+	err := c.r.Close()
+	c.r = nil
+	if err != nil {
+		return &StoreError{c.meta.ID, err}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package gob
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestContentSeek(t *testing.T) {
+	g := newTestGob()
+	content := "0123456789abcdefghij"
+	meta := upload(t, g, content, &UploadOptions{})
+	c, err := g.OpenContent(meta, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, s := range []struct {
+		offset int64
+		whence int
+		n      int
+		want   string
+	}{
+		{5, io.SeekStart, 3, "567"},
+		// Backwards, which reopens the object
+		{2, io.SeekStart, 2, "23"},
+		{4, io.SeekCurrent, 3, "89a"},
+		{-4, io.SeekEnd, 4, "ghij"},
+	} {
+		if _, err := c.Seek(s.offset, s.whence); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, s.n)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			t.Fatal(err)
+		} else if string(buf) != s.want {
+			t.Fatalf("seek %d %d got %q, want %q", s.offset, s.whence, buf, s.want)
+		}
+	}
+	if _, err := c.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("seeked before the start")
+	}
+	c.Seek(0, io.SeekStart)
+	if got, err := ioutil.ReadAll(c); err != nil {
+		t.Fatal(err)
+	} else if string(got) != content {
+		t.Fatalf("got %q", got)
+	}
+	if c.Err() != nil {
+		t.Fatal(c.Err())
+	}
+}
+
+func TestOpenContent(t *testing.T) {
+	g := newTestGob()
+	meta := upload(t, g, "limited", &UploadOptions{Views: 1})
+	if _, err := g.OpenContent(meta, ""); err == nil {
+		t.Fatal("opened a gob with limited views")
+	}
+	meta = upload(t, g, strings.Repeat("secret", 10), &UploadOptions{EncryptKey: "k1"})
+	if _, err := g.OpenContent(meta, ""); err != ErrKeyRequired {
+		t.Fatalf("got %v, want ErrKeyRequired", err)
+	}
+	c, err := g.OpenContent(meta, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
@@ -0,0 +1,90 @@
+package gob
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/kinghrothgar/gobin/pkg/store"
+	"github.com/levenlabs/errctx"
+)
+
+// Errors returned by Gob that are the user's doing rather than a failure
+var (
+	// ErrNotFound is returned when there is no gob with the id or secret
+	ErrNotFound = errors.New("gob not found")
+	// ErrExpired is returned when the gob expired, has no views left or its
+	// content is gone
+	ErrExpired = errors.New("gob expired or was deleted")
+	// ErrDeleted is returned when the gob was deleted, for as long as its
+	// tombstone is kept
+	ErrDeleted = errors.New("gob was deleted")
+	// ErrKeyRequired is returned when downloading an encrypted gob without
+	// its key
+	ErrKeyRequired = errors.New("gob is encrypted, the key is required")
+	// ErrBadKey is returned when downloading an encrypted gob with the wrong
+	// key
+	ErrBadKey = errors.New("gob encryption key is wrong")
+	// ErrTooLarge is returned by Upload when the gob is bigger than
+	// UploadOptions.MaxSize
+	ErrTooLarge = errors.New("gob is too large")
+	// ErrConflict is returned by Upload when it couldn't find an unused id
+	ErrConflict = errors.New("gob already exists")
+	// ErrWrongSecret is returned when the secret isn't the gob's
+	ErrWrongSecret = errors.New("wrong gob secret")
+	// ErrAppendConflict is returned by Append when the gob was changed by
+	// another process at the same time
+	ErrAppendConflict = errors.New("gob was appended to concurrently")
+	// ErrUploading is returned for a gob that's still being uploaded, by any
+	// process. Live ones can be followed instead.
+	ErrUploading = errors.New("gob is still uploading")
+	// ErrNotUploading is returned by Follow when the gob isn't a live upload
+	// in this process, e.g. because it's done
+	ErrNotUploading = errors.New("gob is not a live upload")
+	// ErrUploadFailed is returned by a Follower when the upload being
+	// followed failed, so the gob is gone
+	ErrUploadFailed = errors.New("gob upload failed")
+	// ErrLiveNotAllowed is returned by Upload for live uploads that are
+	// encrypted or have limited views
+	ErrLiveNotAllowed = errors.New("live gobs can't be encrypted or have limited views")
+	// ErrHordeNotFound is returned when there is no horde with the name or
+	// secret
+	ErrHordeNotFound = errors.New("horde not found")
+	// ErrHordeExpired is returned when the horde expired, until it's reaped
+	ErrHordeExpired = errors.New("horde expired")
+)
+
+// StoreError is returned when the store fails, as opposed to the db or the
+// user
+type StoreError struct {
+	ID  string
+	Err error
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("store %s failed: %v", e.ID, e.Err)
+}
+
+// dbError turns db.ErrNotFound into ErrNotFound
+func dbError(err error) error {
+	switch errctx.Base(err) {
+	case db.ErrNotFound:
+		return ErrNotFound
+	case db.ErrConflict:
+		return ErrConflict
+	}
+	return err
+}
+
+// storeError turns store.ErrBadKey into ErrBadKey and a missing object into
+// ErrExpired, since the metadata said it was there. Anything else is a
+// *StoreError.
+func storeError(id string, err error) error {
+	switch errctx.Base(err) {
+	case store.ErrNotFound:
+		return ErrExpired
+	case store.ErrBadKey:
+		return ErrBadKey
+	}
+	return &StoreError{id, err}
+}
@@ -1,7 +1,10 @@
 package gob
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"database/sql"
 	"fmt"
 	"io"
 	"time"
@@ -9,160 +12,430 @@ import (
 	"github.com/kinghrothgar/gobin/pkg/db"
 	"github.com/kinghrothgar/gobin/pkg/store"
 	"github.com/levenlabs/errctx"
+	"github.com/levenlabs/go-llog"
 )
 
 // TODO review concurrency
 // TODO review ctx
 
-// TODO how to pass this in
-var bucketName = "gobin-io-test"
-
 type Gob struct {
-	ctx context.Context
-	db  *db.DB
+	ctx   context.Context
+	db    db.DB
+	store store.Backend
 }
 
-func NewGob(ctx context.Context, db *db.DB) *Gob {
-	return &Gob{ctx, db}
+func NewGob(ctx context.Context, db db.DB, backend store.Backend) *Gob {
+	return &Gob{ctx, db, backend}
 }
 
 // cleanUpMetadata deletes metadata and passes nil, error
-func (gob *Gob) failedUploadHelper(secret string, err error) (*db.Metadata, error) {
-	gob.db.DeleteMetadataBySecret(secret)
+func (gob *Gob) failedUploadHelper(id string, err error) (*db.Metadata, error) {
+	gob.db.DeleteMetadataByID(id)
 	return nil, err
 }
 
-// TODO does object dangle of upload not completed?
-func (gob *Gob) Upload(reader io.Reader, encryptKey string, filename string) (*db.Metadata, error) {
-	meta, err := gob.db.NewInsertedMetadata(3)
-	if err != nil {
-		return nil, err
+// UploadOptions are the optional settings of an uploaded gob
+type UploadOptions struct {
+	// EncryptKey encrypts the gob in the store, it's then needed to download
+	EncryptKey string
+	Filename   string
+	// ExpireDate is when the gob expires, the zero Time is never
+	ExpireDate time.Time
+	// Views is how many times the gob can be downloaded before it's deleted,
+	// 0 is unlimited
+	Views int64
+	// MaxSize is the largest the gob can be in bytes, 0 is no limit
+	MaxSize int64
+	// Live lets the gob be followed with Follow while it uploads. It can't be
+	// encrypted or have limited views, since following skips both.
+	Live bool
+	// Started is called, if set, once the gob has its id and before its
+	// content is read, e.g. to give out its URL while it uploads
+	Started func(meta *db.Metadata)
+}
+
+// maxSizeReader returns ErrTooLarge once more than n bytes are read
+type maxSizeReader struct {
+	r io.Reader
+	n int64
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	if m.n -= int64(n); m.n < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+func (gob *Gob) Upload(reader io.Reader, opts *UploadOptions) (*db.Metadata, error) {
+	if opts.Live && (opts.EncryptKey != "" || opts.Views > 0) {
+		return nil, ErrLiveNotAllowed
 	}
-	obj, err := store.NewObject(gob.ctx, bucketName, meta.ID)
+	meta, err := db.NewInsertedMetadata(gob.db, 3)
 	if err != nil {
-		return gob.failedUploadHelper(meta.Secret, err)
+		return nil, dbError(err)
 	}
+	obj := store.NewObject(gob.store, meta.ID)
 	// TODO: should I be checking if it exists or let metadata be master
 	if exists, err := obj.Exists(gob.ctx); err != nil {
-		return gob.failedUploadHelper(meta.Secret, err)
+		return gob.failedUploadHelper(meta.ID, &StoreError{meta.ID, err})
 	} else if exists {
-		err := errctx.Mark(fmt.Errorf("store %s already exists", meta.ID))
-		return gob.failedUploadHelper(meta.Secret, err)
+		return gob.failedUploadHelper(meta.ID, ErrConflict)
+	}
+	if opts.MaxSize > 0 {
+		reader = &maxSizeReader{reader, opts.MaxSize}
+	}
+	uploaded := false
+	if opts.Live {
+		// Finished after the metadata is updated, so followers are never
+		// sent to a gob that isn't done
+		reader = io.TeeReader(reader, live.start(meta.ID))
+		defer func() { live.finish(meta.ID, uploaded) }()
+	}
+	defer gob.heartbeat(meta.ID)()
+	if opts.Started != nil {
+		opts.Started(meta)
 	}
 	// TODO how to set salt?
-	if encryptKey != "" {
+	if opts.EncryptKey != "" {
 		meta.Encrypted = true
-		obj.Key(encryptKey, "saltsaltsalt")
+		obj.Key(opts.EncryptKey, "saltsaltsalt")
 	}
 
 	// Sniff content type
 	// Only the first 512 bytes are used to sniff the content type.
 	buffer := make([]byte, 512)
-	bytesRead, err := reader.Read(buffer)
-	if err != nil {
-		return gob.failedUploadHelper(meta.Secret, errctx.Mark(err))
+	bytesRead, err := io.ReadFull(reader, buffer)
+	if err == ErrTooLarge {
+		return gob.failedUploadHelper(meta.ID, err)
+	} else if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return gob.failedUploadHelper(meta.ID, errctx.Mark(err))
 	}
 	meta.SetContentType(buffer[:bytesRead])
 
 	// Write to storage
-	w := obj.NewWriter(gob.ctx)
-	w.Write(buffer)
-	meta.Size, err = store.Copy(gob.ctx, w, reader)
+	w, err := obj.NewWriter(gob.ctx)
 	if err != nil {
-		return gob.failedUploadHelper(meta.Secret, errctx.Mark(err))
+		return gob.failedUploadHelper(meta.ID, &StoreError{meta.ID, err})
+	}
+	// Throws away what was written if anything below fails, it does nothing
+	// once w is closed
+	defer w.Abort()
+	if _, err := w.Write(buffer[:bytesRead]); err != nil {
+		return gob.failedUploadHelper(meta.ID, &StoreError{meta.ID, err})
+	}
+	hash := sha256.New()
+	hash.Write(buffer[:bytesRead])
+	meta.Size, err = store.Copy(gob.ctx, io.MultiWriter(w, hash), reader)
+	if errctx.Base(err) == ErrTooLarge {
+		return gob.failedUploadHelper(meta.ID, ErrTooLarge)
+	} else if err != nil {
+		return gob.failedUploadHelper(meta.ID, errctx.Mark(err))
 	}
 	meta.Size += int64(bytesRead)
+	meta.ContentHash, meta.HashState = contentHash(hash)
 	if err := w.Close(); err != nil {
-		// TODO this could leave a dangling storage obj?
-		err = errctx.Mark(fmt.Errorf("failed to close %s store: %v", meta.ID, err))
-		return gob.failedUploadHelper(meta.Secret, err)
+		return gob.failedUploadHelper(meta.ID, &StoreError{meta.ID, err})
 	}
 
-	// Update metadata
-	meta.SetFilename(filename)
-	if err := gob.db.UpdateMetadata(meta); err != nil {
-		err = errctx.Mark(fmt.Errorf("failed to update %s metadata: %v", meta.ID, err))
-		return nil, err
+	// Update metadata. The object is committed by now, so it goes too if
+	// that fails. The content is last since it ends the upload, so the gob
+	// is never seen without its options.
+	failed := func(err error) (*db.Metadata, error) {
+		if err := obj.Delete(gob.ctx); err != nil {
+			llog.Warn("failed to delete object of failed upload", llog.KV{"id": meta.ID}, llog.ErrKV(err))
+		}
+		return gob.failedUploadHelper(meta.ID, err)
 	}
+	if err := gob.SetOptions(meta, opts); err != nil {
+		return failed(err)
+	}
+	if err := gob.db.UpdateContent(meta, 0, ""); err != nil {
+		return failed(errctx.Mark(fmt.Errorf("failed to update %s content: %v", meta.ID, err)))
+	}
+	uploaded = true
 	return meta, nil
 }
 
+// UploadHeartbeat is how often an upload touches its metadata, so the reaper
+// can tell it from one whose process died
+const UploadHeartbeat = time.Minute
+
+// heartbeat touches the metadata of the uploading gob with id every
+// UploadHeartbeat until the returned func is called
+func (gob *Gob) heartbeat(id string) func() {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(UploadHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if err := gob.db.TouchUpload(id, time.Now()); err != nil {
+				llog.Warn("failed to touch uploading gob", llog.KV{"id": id}, llog.ErrKV(err))
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// SetOptions sets the filename, expire date and views of an uploaded gob to
+// those in opts. The rest of opts only matter to Upload.
+func (gob *Gob) SetOptions(meta *db.Metadata, opts *UploadOptions) error {
+	meta.SetFilename(opts.Filename)
+	if opts.ExpireDate.IsZero() {
+		meta.ExpireDate = sql.NullTime{}
+	} else {
+		meta.SetExpireDate(opts.ExpireDate)
+	}
+	meta.SetViewsLeft(opts.Views)
+	if err := gob.db.UpdateMetadata(meta); err != nil {
+		return errctx.Mark(fmt.Errorf("failed to update %s metadata: %v", meta.ID, err))
+	}
+	return nil
+}
+
 func (gob *Gob) GetMetadata(id string) (*db.Metadata, error) {
 	meta, err := gob.db.GetMetadataByID(id)
-	// TODO probably should return typed error if id does not exist
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
-	// TODO probably should return typed error if Expired
-	if meta.ExpireDate.Valid && time.Now().After(meta.ExpireDate.Time) {
-		return nil, fmt.Errorf("%s expired", meta.ID)
+	if err := checkGone(meta); err != nil {
+		return nil, err
 	}
 	return meta, nil
 }
 
+// checkGone returns ErrDeleted or ErrExpired if the gob of meta is gone, and
+// ErrUploading if it isn't there yet
+func checkGone(meta *db.Metadata) error {
+	if meta.Deleted() {
+		return ErrDeleted
+	}
+	if meta.Expired(time.Now()) {
+		return ErrExpired
+	}
+	if meta.Uploading {
+		return ErrUploading
+	}
+	return nil
+}
+
+// Encodings a gob can be downloaded in
+const (
+	EncodingIdentity = ""
+	// EncodingZstd is how gobs are stored, so it's the cheapest
+	EncodingZstd = "zstd"
+	EncodingGzip = "gzip"
+)
+
 func (gob *Gob) Download(w io.Writer, meta *db.Metadata, encryptKey string) error {
-	obj, err := store.NewObject(gob.ctx, bucketName, meta.ID)
+	return gob.DownloadEncoded(w, meta, encryptKey, EncodingIdentity)
+}
+
+// DownloadEncoded is Download with the gob compressed with encoding
+func (gob *Gob) DownloadEncoded(w io.Writer, meta *db.Metadata, encryptKey, encoding string) error {
+	if exists, err := store.NewObject(gob.store, meta.ID).Exists(gob.ctx); err != nil {
+		return &StoreError{meta.ID, err}
+	} else if !exists {
+		return ErrExpired
+	}
+	objs, err := gob.objects(meta, encryptKey)
 	if err != nil {
 		return err
 	}
-	if exists, err := obj.Exists(gob.ctx); err != nil {
+
+	// Take the view up front so concurrent downloads can't both get the
+	// last one, and give it back if the download fails
+	var viewsLeft int64
+	if meta.ViewsLeft.Valid {
+		var err error
+		if viewsLeft, err = gob.db.DecrementViewsLeft(meta.ID); errctx.Base(err) == db.ErrNotFound {
+			return ErrExpired
+		} else if err != nil {
+			return errctx.Mark(fmt.Errorf("failed to take a view of %s: %v", meta.ID, err))
+		}
+	}
+	cw := &countingWriter{w: w}
+	err = gob.download(cw, objs, meta, encoding)
+	// Once any of it reached the client the view was used
+	if err != nil && cw.n == 0 {
+		if meta.ViewsLeft.Valid {
+			if err := gob.db.IncrementViewsLeft(meta.ID); err != nil {
+				llog.Warn("failed to give back view", llog.KV{"id": meta.ID}, llog.ErrKV(err))
+			}
+		}
 		return err
-	} else if !exists {
-		return fmt.Errorf("store %s does not exist", meta.ID)
 	}
-	// TODO how to set salt?
-	if meta.Encrypted && encryptKey == "" {
-		// TODO probably should return typed error
-		return fmt.Errorf("store %s requires encrypt key", meta.ID)
-	} else if meta.Encrypted {
-		obj.Key(encryptKey, "saltsaltsalt")
+	if meta.ViewsLeft.Valid && viewsLeft == 0 {
+		gob.burn(meta)
 	}
+	return err
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
 
-	r, err := obj.NewReader(gob.ctx)
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (gob *Gob) download(w io.Writer, objs []*store.Object, meta *db.Metadata, encoding string) error {
+	var r io.ReadCloser
+	var err error
+	switch encoding {
+	case EncodingIdentity, EncodingGzip:
+		r, err = store.NewObjectsReader(gob.ctx, objs)
+	case EncodingZstd:
+		// Its parts are whole zstd streams, so joined they're one too
+		r, err = store.NewObjectsCompressedReader(gob.ctx, objs)
+	default:
+		return errctx.Mark(fmt.Errorf("unknown encoding %q", encoding))
+	}
 	if err != nil {
-		// TODO return typed error for CustomerEncryptionKeyIsIncorrect 400
-		return errctx.Mark(fmt.Errorf("failed to get store %s reader: %v", meta.ID, err))
+		return storeError(meta.ID, err)
+	}
+	var gw *gzip.Writer
+	if encoding == EncodingGzip {
+		// Speed over size, it's done for every download
+		gw, _ = gzip.NewWriterLevel(w, gzip.BestSpeed)
+		w = gw
 	}
 	if _, err := store.Copy(gob.ctx, w, r); err != nil {
+		r.Close()
 		return errctx.Mark(fmt.Errorf("failed to copy %s from store: %v", meta.ID, err))
 	}
 	if err := r.Close(); err != nil {
-		return errctx.Mark(fmt.Errorf("failed to close %s store: %v", meta.ID, err))
+		return &StoreError{meta.ID, err}
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			return errctx.Mark(fmt.Errorf("failed to gzip %s: %v", meta.ID, err))
+		}
 	}
 	return nil
 }
 
+// burn deletes a gob after its last view. It's already unreadable since it
+// has no views left, so if deleting fails it's left for the reaper.
+func (gob *Gob) burn(meta *db.Metadata) {
+	if err := gob.delete(meta); err != nil && err != ErrExpired && err != ErrDeleted {
+		llog.Warn("failed to delete gob with no views left", llog.KV{"id": meta.ID}, llog.ErrKV(err))
+		return
+	}
+	llog.Debug("deleted gob with no views left", llog.KV{"id": meta.ID})
+}
+
 func (gob *Gob) Expire(secret string) (*db.Metadata, error) {
-	meta, err := gob.db.GetMetadataBySecret(secret)
-	// TODO probably should return typed error if id does not exist
+	meta, err := gob.GetMetadataBySecret(secret)
 	if err != nil {
 		return nil, err
 	}
-	if meta.ExpireDate.Valid && time.Now().After(meta.ExpireDate.Time) {
-		return nil, fmt.Errorf("%s expired", meta.ID)
+	return gob.expire(meta)
+}
+
+// ExpireByID is Expire for a user that knows the id and has to prove they
+// own it with the secret
+func (gob *Gob) ExpireByID(id, secret string) (*db.Metadata, error) {
+	meta, err := gob.db.GetMetadataByID(id)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	if !checkSecret(meta, secret) {
+		return nil, ErrWrongSecret
+	}
+	if err := checkGone(meta); err != nil {
+		return nil, err
 	}
+	return gob.expire(meta)
+}
+
+func (gob *Gob) expire(meta *db.Metadata) (*db.Metadata, error) {
 	meta.SetExpireDate(time.Now())
-	if err = gob.db.UpdateMetadata(meta); err != nil {
-		return nil, fmt.Errorf("failed to expire %s gob: %v", meta.ID, err)
+	if err := gob.db.UpdateMetadata(meta); err != nil {
+		return nil, errctx.Mark(fmt.Errorf("failed to expire %s gob: %v", meta.ID, err))
 	}
 	return meta, nil
 }
 
+// checkSecret is whether secret is meta's
+func checkSecret(meta *db.Metadata, secret string) bool {
+	return db.SecretMatches(meta.SecretHash, secret)
+}
+
+// GetMetadataBySecret is GetMetadata for the gob with secret
+func (gob *Gob) GetMetadataBySecret(secret string) (*db.Metadata, error) {
+	meta, err := gob.db.GetMetadataBySecret(secret)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	if err := checkGone(meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// Delete deletes the store object and metadata of the gob with secret
 func (gob *Gob) Delete(secret string) error {
 	meta, err := gob.db.GetMetadataBySecret(secret)
-	// TODO probably should return typed error if id does not exist
 	if err != nil {
-		return err
+		return dbError(err)
 	}
-	obj, err := store.NewObject(gob.ctx, bucketName, meta.ID)
+	return gob.delete(meta)
+}
 
+// DeleteByID is Delete for a user that knows the id and has to prove they
+// own it with the secret
+func (gob *Gob) DeleteByID(id, secret string) error {
+	meta, err := gob.db.GetMetadataByID(id)
 	if err != nil {
-		return err
+		return dbError(err)
 	}
-	if exists, err := obj.Exists(gob.ctx); err != nil {
+	if !checkSecret(meta, secret) {
+		return ErrWrongSecret
+	}
+	return gob.delete(meta)
+}
+
+// delete removes the object before the metadata is made a tombstone, so if
+// the store fails the gob can still be found to retry. It returns ErrDeleted
+// if it already was, and ErrExpired, after cleaning up, if the gob had
+// already expired or its object was gone.
+func (gob *Gob) delete(meta *db.Metadata) error {
+	if meta.Deleted() {
+		return ErrDeleted
+	}
+	gone := meta.Expired(time.Now())
+	existed, _, err := gob.deleteObjects(meta)
+	if err != nil {
 		return err
-	} else if exists {
-		obj.Delete(gob.ctx)
 	}
-	return gob.db.DeleteMetadataBySecret(secret)
+	gone = gone || !existed
+	if err := gob.db.MarkMetadataDeleted(meta.ID, time.Now()); errctx.Base(err) == db.ErrNotFound {
+		// Deleted at the same time by someone else
+		return ErrDeleted
+	} else if err != nil {
+		return errctx.Mark(fmt.Errorf("failed to delete %s metadata: %v", meta.ID, err))
+	}
+	// Parts appended since meta was got have to go too, none can be added now
+	if tombstone, err := gob.db.GetMetadataByID(meta.ID); err != nil {
+		llog.Warn("failed to get deleted gob", llog.KV{"id": meta.ID}, llog.ErrKV(err))
+	} else if tombstone.Parts != meta.Parts {
+		if _, _, err := gob.deleteObjects(tombstone); err != nil {
+			llog.Warn("failed to delete parts of deleted gob", llog.KV{"id": meta.ID}, llog.ErrKV(err))
+		}
+	}
+	if gone {
+		return ErrExpired
+	}
+	return nil
 }
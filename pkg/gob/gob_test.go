@@ -0,0 +1,296 @@
+package gob
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/kinghrothgar/gobin/pkg/store"
+	"github.com/levenlabs/errctx"
+)
+
+func newTestGob() *Gob {
+	return NewGob(context.Background(), db.NewMemoryDB(), store.NewMemoryBackend())
+}
+
+func upload(t *testing.T, g *Gob, content string, opts *UploadOptions) *db.Metadata {
+	t.Helper()
+	meta, err := g.Upload(strings.NewReader(content), opts)
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	return meta
+}
+
+func download(g *Gob, id, encryptKey string) (string, error) {
+	meta, err := g.GetMetadata(id)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	err = g.Download(buf, meta, encryptKey)
+	return buf.String(), err
+}
+
+func TestUploadDownload(t *testing.T) {
+	g := newTestGob()
+	content := strings.Repeat("hello gobin\n", 100)
+	meta := upload(t, g, content, &UploadOptions{Filename: "hello.txt"})
+	if meta.Size != int64(len(content)) {
+		t.Fatalf("got size %d, want %d", meta.Size, len(content))
+	} else if !meta.ContentHash.Valid {
+		t.Fatal("content hash not set")
+	} else if meta.Secret == "" {
+		t.Fatal("secret not set")
+	}
+
+	got, err := download(g, meta.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	} else if got != content {
+		t.Fatalf("got %q, want %q", got, content)
+	}
+	stored, err := g.GetMetadata(meta.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if !stored.Filename.Valid || stored.Filename.String != "hello.txt" {
+		t.Fatalf("got filename %v", stored.Filename)
+	}
+}
+
+func TestUploadEncrypted(t *testing.T) {
+	g := newTestGob()
+	meta := upload(t, g, "secret stuff", &UploadOptions{EncryptKey: "k1"})
+	if _, err := download(g, meta.ID, ""); err != ErrKeyRequired {
+		t.Fatalf("got %v, want ErrKeyRequired", err)
+	}
+	if got, err := download(g, meta.ID, "k1"); err != nil {
+		t.Fatal(err)
+	} else if got != "secret stuff" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestUploadTooLarge(t *testing.T) {
+	g := newTestGob()
+	for _, size := range []int{10, 1000} {
+		_, err := g.Upload(strings.NewReader(strings.Repeat("x", size+1)), &UploadOptions{MaxSize: int64(size)})
+		if errctx.Base(err) != ErrTooLarge {
+			t.Fatalf("size %d: got %v, want ErrTooLarge", size, err)
+		}
+	}
+	expired, err := g.db.GetExpiredMetadata(time.Now().Add(time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(expired) != 0 {
+		t.Fatalf("failed uploads left %d gobs", len(expired))
+	}
+}
+
+func TestDownloadViews(t *testing.T) {
+	g := newTestGob()
+	meta := upload(t, g, "twice", &UploadOptions{Views: 2})
+	for i := 0; i < 2; i++ {
+		if got, err := download(g, meta.ID, ""); err != nil {
+			t.Fatalf("view %d: %v", i, err)
+		} else if got != "twice" {
+			t.Fatalf("view %d: got %q", i, got)
+		}
+	}
+	if _, err := download(g, meta.ID, ""); err != ErrDeleted {
+		t.Fatalf("got %v, want ErrDeleted after the last view", err)
+	}
+}
+
+// failWriter takes n bytes then fails
+type failWriter struct {
+	n int
+}
+
+func (f *failWriter) Write(p []byte) (int, error) {
+	if len(p) > f.n {
+		n := f.n
+		f.n = 0
+		return n, io.ErrClosedPipe
+	}
+	f.n -= len(p)
+	return len(p), nil
+}
+
+func TestDownloadViewsFailed(t *testing.T) {
+	g := newTestGob()
+	meta := upload(t, g, "twice", &UploadOptions{Views: 2})
+	// Nothing reached the client so the view is given back
+	if err := g.Download(&failWriter{}, meta, ""); err == nil {
+		t.Fatal("download to a failed writer succeeded")
+	}
+	if stored, err := g.GetMetadata(meta.ID); err != nil {
+		t.Fatal(err)
+	} else if stored.ViewsLeft.Int64 != 2 {
+		t.Fatalf("got %d views left, want 2", stored.ViewsLeft.Int64)
+	}
+	// Some of it did so the view is used, and the last one burns the gob
+	for i := 0; i < 2; i++ {
+		if err := g.Download(&failWriter{n: 2}, meta, ""); err == nil {
+			t.Fatal("download to a failed writer succeeded")
+		}
+	}
+	if _, err := download(g, meta.ID, ""); err != ErrDeleted {
+		t.Fatalf("got %v, want ErrDeleted after the last view", err)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	g := newTestGob()
+	meta := upload(t, g, "expire me", &UploadOptions{})
+	if _, err := g.ExpireByID(meta.ID, "wrong"); err != ErrWrongSecret {
+		t.Fatalf("got %v, want ErrWrongSecret", err)
+	}
+	if _, err := g.Expire(meta.Secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.GetMetadata(meta.ID); err != ErrExpired {
+		t.Fatalf("got %v, want ErrExpired", err)
+	}
+	if _, err := g.Expire(meta.Secret); err != ErrExpired {
+		t.Fatalf("got %v, want ErrExpired expiring twice", err)
+	}
+
+	meta = upload(t, g, "ttl", &UploadOptions{ExpireDate: time.Now().Add(-time.Second)})
+	if _, err := g.GetMetadata(meta.ID); err != ErrExpired {
+		t.Fatalf("got %v, want ErrExpired for a past expire date", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	g := newTestGob()
+	meta := upload(t, g, "delete me", &UploadOptions{})
+	if err := g.DeleteByID(meta.ID, "wrong"); err != ErrWrongSecret {
+		t.Fatalf("got %v, want ErrWrongSecret", err)
+	}
+	if err := g.DeleteByID(meta.ID, meta.Secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.GetMetadata(meta.ID); err != ErrDeleted {
+		t.Fatalf("got %v, want ErrDeleted", err)
+	}
+	if err := g.DeleteByID(meta.ID, meta.Secret); err != ErrDeleted {
+		t.Fatalf("got %v, want ErrDeleted deleting twice", err)
+	}
+	if exists, err := store.NewObject(g.store, meta.ID).Exists(g.ctx); err != nil {
+		t.Fatal(err)
+	} else if exists {
+		t.Fatal("object left after delete")
+	}
+
+	meta = upload(t, g, "delete me by secret", &UploadOptions{})
+	if err := g.Delete(meta.Secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Delete(meta.Secret); err != ErrDeleted {
+		t.Fatalf("got %v, want ErrDeleted deleting twice", err)
+	}
+}
+
+func TestReapTombstones(t *testing.T) {
+	g := newTestGob()
+	meta := upload(t, g, "delete me", &UploadOptions{})
+	if err := g.Delete(meta.Secret); err != nil {
+		t.Fatal(err)
+	}
+	// Kept by Reap until it's older than TombstoneAge
+	if _, _, err := g.Reap(10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.GetMetadata(meta.ID); err != ErrDeleted {
+		t.Fatalf("got %v, want ErrDeleted", err)
+	}
+	if _, err := g.db.DeleteTombstones(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.GetMetadata(meta.ID); err != ErrNotFound {
+		t.Fatalf("got %v, want ErrNotFound once the tombstone is gone", err)
+	}
+}
+
+func TestReapReclaimed(t *testing.T) {
+	g := newTestGob()
+	content := strings.Repeat("compresses well ", 1000)
+	secret := upload(t, g, content, &UploadOptions{}).Secret
+	meta, _, err := g.Append(secret, strings.NewReader(content), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored int64
+	for _, obj := range withParts(store.NewObject(g.store, meta.ID), meta) {
+		attrs, err := obj.Stat(g.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored += attrs.Size
+	}
+	if stored >= meta.Size {
+		t.Fatalf("stored %d bytes of %d, want them compressed", stored, meta.Size)
+	}
+	// Nothing is reclaimed for a gob whose object is already gone
+	gone := upload(t, g, content, &UploadOptions{})
+	if err := g.store.Delete(g.ctx, gone.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{secret, gone.Secret} {
+		if _, err := g.Expire(secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, reclaimed, err := g.Reap(10); err != nil {
+		t.Fatal(err)
+	} else if n != 2 || reclaimed != stored {
+		t.Fatalf("reaped %d gobs and %d bytes, want 2 and the %d stored", n, reclaimed, stored)
+	}
+}
+
+func TestReapStaleUploads(t *testing.T) {
+	g := newTestGob()
+	// Uploads whose process died leave metadata like this
+	stale, err := db.NewInsertedMetadata(g.db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, pw, done := startUpload(t, g, &UploadOptions{})
+	if err := g.db.TouchUpload(meta.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := g.ReapStaleUploads(0, 10); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("reaped %d, want only the stale upload", n)
+	}
+	if _, err := g.GetMetadata(stale.ID); err != ErrNotFound {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+	pw.Write([]byte("still going"))
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// One taken for stale while it's still going fails when it's done
+	meta, pw, done = startUpload(t, g, &UploadOptions{})
+	if n, err := g.ReapStaleUploads(-time.Hour, 10); err != nil || n != 1 {
+		t.Fatalf("reaped %d: %v", n, err)
+	}
+	pw.Write([]byte("too late"))
+	pw.Close()
+	if err := <-done; err == nil {
+		t.Fatal("upload of a reaped gob succeeded")
+	}
+	if exists, err := g.store.Exists(g.ctx, meta.ID); err != nil {
+		t.Fatal(err)
+	} else if exists {
+		t.Fatal("reaped upload left its object")
+	}
+}
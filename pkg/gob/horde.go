@@ -0,0 +1,205 @@
+package gob
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/kinghrothgar/gobin/pkg/store"
+	"github.com/levenlabs/errctx"
+)
+
+// Formats a horde can be downloaded as
+const (
+	ArchiveTar = "tar"
+	ArchiveZip = "zip"
+)
+
+// hordeError turns db.ErrNotFound into ErrHordeNotFound
+func hordeError(err error) error {
+	if errctx.Base(err) == db.ErrNotFound {
+		return ErrHordeNotFound
+	}
+	return err
+}
+
+// AddToHorde puts the uploaded gob of meta in the horde called name, creating
+// the horde with expireDate if it doesn't exist yet. The zero Time is never.
+// The gob expires with the horde if it would have outlived it. It returns the
+// horde and whether it was created, since only then should its secret be
+// given out.
+func (gob *Gob) AddToHorde(meta *db.Metadata, name string, expireDate time.Time) (*db.Horde, bool, error) {
+	horde, created, err := gob.getOrCreateHorde(name, expireDate)
+	if err != nil {
+		return nil, false, err
+	}
+	if horde.Expired(time.Now()) {
+		return nil, false, ErrHordeExpired
+	}
+	meta.SetHorde(horde.Name)
+	if horde.ExpireDate.Valid && (!meta.ExpireDate.Valid || meta.ExpireDate.Time.After(horde.ExpireDate.Time)) {
+		meta.SetExpireDate(horde.ExpireDate.Time)
+	}
+	if err := gob.db.UpdateMetadata(meta); err != nil {
+		return nil, false, errctx.Mark(fmt.Errorf("failed to add %s to horde %s: %v", meta.ID, name, err))
+	}
+	return horde, created, nil
+}
+
+// CheckHorde returns ErrHordeExpired if the horde called name has expired, so
+// an upload into it can fail before it starts. It not existing yet is fine,
+// AddToHorde creates it.
+func (gob *Gob) CheckHorde(name string) error {
+	horde, err := gob.db.GetHorde(name)
+	if errctx.Base(err) == db.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return errctx.Mark(fmt.Errorf("failed to get horde %s: %v", name, err))
+	}
+	if horde.Expired(time.Now()) {
+		return ErrHordeExpired
+	}
+	return nil
+}
+
+func (gob *Gob) getOrCreateHorde(name string, expireDate time.Time) (*db.Horde, bool, error) {
+	// Twice in case another upload creates it between the get and insert
+	for i := 0; i < 2; i++ {
+		horde, err := gob.db.GetHorde(name)
+		if err == nil {
+			return horde, false, nil
+		} else if errctx.Base(err) != db.ErrNotFound {
+			return nil, false, errctx.Mark(fmt.Errorf("failed to get horde %s: %v", name, err))
+		}
+		horde = db.NewHorde(name)
+		if !expireDate.IsZero() {
+			horde.SetExpireDate(expireDate)
+		}
+		err = gob.db.InsertHorde(horde)
+		if err == nil {
+			return horde, true, nil
+		} else if !db.IsUniqueViolation(err) {
+			return nil, false, errctx.Mark(fmt.Errorf("failed to create horde %s: %v", name, err))
+		}
+	}
+	return nil, false, ErrConflict
+}
+
+// GetHorde returns the horde called name and the metadata of its gobs that
+// haven't expired, oldest first
+func (gob *Gob) GetHorde(name string) (*db.Horde, []*db.Metadata, error) {
+	horde, err := gob.db.GetHorde(name)
+	if err != nil {
+		return nil, nil, hordeError(err)
+	}
+	now := time.Now()
+	if horde.Expired(now) {
+		return nil, nil, ErrHordeExpired
+	}
+	all, err := gob.db.GetHordeMetadata(name)
+	if err != nil {
+		return nil, nil, errctx.Mark(fmt.Errorf("failed to get horde %s gobs: %v", name, err))
+	}
+	metas := make([]*db.Metadata, 0, len(all))
+	for _, meta := range all {
+		if !meta.Expired(now) {
+			metas = append(metas, meta)
+		}
+	}
+	return horde, metas, nil
+}
+
+// ExpireHorde expires the horde with secret and every gob in it
+func (gob *Gob) ExpireHorde(secret string) (*db.Horde, error) {
+	horde, err := gob.db.GetHordeBySecret(secret)
+	if err != nil {
+		return nil, hordeError(err)
+	}
+	now := time.Now()
+	if horde.Expired(now) {
+		return nil, ErrHordeExpired
+	}
+	if err := gob.db.ExpireHorde(horde.Name, now); err != nil {
+		return nil, errctx.Mark(fmt.Errorf("failed to expire horde %s: %v", horde.Name, err))
+	}
+	horde.SetExpireDate(now)
+	return horde, nil
+}
+
+// archiveName is the name of the gob of meta in a horde archive. The id keeps
+// it unique.
+func archiveName(meta *db.Metadata) string {
+	if !meta.Filename.Valid {
+		return meta.ID
+	}
+	return meta.ID + "-" + path.Base(meta.Filename.String)
+}
+
+// DownloadHorde writes the gobs of metas as a tar or zip archive. Encrypted
+// gobs and ones with limited views are left out, since an archive can't have
+// keys and downloading the horde shouldn't burn them.
+func (gob *Gob) DownloadHorde(w io.Writer, metas []*db.Metadata, format string) error {
+	var tw *tar.Writer
+	var zw *zip.Writer
+	switch format {
+	case ArchiveTar:
+		tw = tar.NewWriter(w)
+	case ArchiveZip:
+		zw = zip.NewWriter(w)
+	default:
+		return errctx.Mark(fmt.Errorf("unknown archive format %q", format))
+	}
+	for _, meta := range metas {
+		if meta.Encrypted || meta.ViewsLeft.Valid {
+			continue
+		}
+		var fw io.Writer
+		var err error
+		if tw != nil {
+			err = tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     archiveName(meta),
+				Size:     meta.Size,
+				Mode:     0644,
+				ModTime:  meta.LastModified(),
+			})
+			fw = tw
+		} else {
+			fw, err = zw.CreateHeader(&zip.FileHeader{
+				Name:     archiveName(meta),
+				Method:   zip.Deflate,
+				Modified: meta.LastModified(),
+			})
+		}
+		if err != nil {
+			return errctx.Mark(fmt.Errorf("failed to archive %s: %v", meta.ID, err))
+		}
+		if err := gob.archiveOne(fw, meta); err != nil {
+			return err
+		}
+	}
+	if tw != nil {
+		return errctx.Mark(tw.Close())
+	}
+	return errctx.Mark(zw.Close())
+}
+
+func (gob *Gob) archiveOne(w io.Writer, meta *db.Metadata) error {
+	objs, err := gob.objects(meta, "")
+	if err != nil {
+		return err
+	}
+	r, err := store.NewObjectsReader(gob.ctx, objs)
+	if err != nil {
+		return storeError(meta.ID, err)
+	}
+	defer r.Close()
+	if _, err := store.Copy(gob.ctx, w, r); err != nil {
+		return errctx.Mark(fmt.Errorf("failed to copy %s from store: %v", meta.ID, err))
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package gob
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/levenlabs/errctx"
+)
+
+// liveWindow is how much of a live upload is kept for followers. Like tail -f,
+// ones that join late or fall behind skip to the last liveWindow bytes.
+const liveWindow = 64 << 10
+
+// live has the uploads in this process that can be followed, by gob id
+var live = &liveHub{uploads: map[string]*liveUpload{}}
+
+type liveHub struct {
+	mu      sync.RWMutex
+	uploads map[string]*liveUpload
+}
+
+func (h *liveHub) start(id string) *liveUpload {
+	u := &liveUpload{changed: make(chan struct{})}
+	h.mu.Lock()
+	h.uploads[id] = u
+	h.mu.Unlock()
+	return u
+}
+
+func (h *liveHub) get(id string) *liveUpload {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.uploads[id]
+}
+
+// finish ends the upload for its followers, who get what's left first
+func (h *liveHub) finish(id string, uploaded bool) {
+	h.mu.Lock()
+	u := h.uploads[id]
+	delete(h.uploads, id)
+	h.mu.Unlock()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.done = true
+	u.failed = !uploaded
+	close(u.changed)
+}
+
+// liveUpload is written the bytes of a gob as they're uploaded
+type liveUpload struct {
+	mu  sync.Mutex
+	buf []byte
+	// start is the offset in the gob of buf[0]
+	start  int64
+	done   bool
+	failed bool
+	// changed is closed and replaced on every write
+	changed chan struct{}
+}
+
+func (u *liveUpload) Write(p []byte) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.buf = append(u.buf, p...)
+	if extra := len(u.buf) - liveWindow; extra > 0 {
+		u.buf = append(u.buf[:0], u.buf[extra:]...)
+		u.start += int64(extra)
+	}
+	close(u.changed)
+	u.changed = make(chan struct{})
+	return len(p), nil
+}
+
+// next returns a copy of the bytes from offset off on, and the offset after
+// them. If there are none it returns a channel that's closed when there are,
+// or true if there won't be. It returns ErrUploadFailed once the upload has
+// failed.
+func (u *liveUpload) next(off int64) ([]byte, int64, <-chan struct{}, bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if off < u.start {
+		off = u.start
+	}
+	end := u.start + int64(len(u.buf))
+	if off < end {
+		data := make([]byte, end-off)
+		copy(data, u.buf[off-u.start:])
+		return data, end, nil, false, nil
+	}
+	if u.failed {
+		return nil, off, nil, true, ErrUploadFailed
+	}
+	return nil, off, u.changed, u.done, nil
+}
+
+// Follower reads a live upload, see Follow
+type Follower struct {
+	ctx context.Context
+	u   *liveUpload
+	off int64
+}
+
+// Follow returns a Follower of the live upload of the gob with id, or
+// ErrNotUploading if it isn't one in this process. Once it has the upload it
+// gets the end of it, even if it finishes before being read.
+func (gob *Gob) Follow(id string) (*Follower, error) {
+	u := live.get(id)
+	if u == nil {
+		return nil, ErrNotUploading
+	}
+	return &Follower{ctx: gob.ctx, u: u}, nil
+}
+
+// WriteTo writes the bytes of the upload to w as they arrive, until the
+// upload ends or the Gob's context is done. Each write is what arrived since
+// the last, so w can flush after each. It returns ErrUploadFailed if the
+// upload did, after writing what it got.
+func (f *Follower) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for {
+		data, next, changed, done, err := f.u.next(f.off)
+		if err != nil {
+			return written, err
+		} else if len(data) > 0 {
+			n, err := w.Write(data)
+			written += int64(n)
+			if err != nil {
+				return written, errctx.Mark(err)
+			}
+			f.off = next
+			continue
+		} else if done {
+			return written, nil
+		}
+		select {
+		case <-f.ctx.Done():
+			return written, errctx.Mark(f.ctx.Err())
+		case <-changed:
+		}
+	}
+}
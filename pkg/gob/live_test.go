@@ -0,0 +1,103 @@
+package gob
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/kinghrothgar/gobin/pkg/db"
+)
+
+// startUpload uploads what's written to the returned pipe, and returns the
+// metadata once the upload has started and a channel of its result
+func startUpload(t *testing.T, g *Gob, opts *UploadOptions) (*db.Metadata, *io.PipeWriter, <-chan error) {
+	t.Helper()
+	pr, pw := io.Pipe()
+	started := make(chan *db.Metadata, 1)
+	opts.Started = func(meta *db.Metadata) { started <- meta }
+	done := make(chan error, 1)
+	go func() {
+		_, err := g.Upload(pr, opts)
+		pr.CloseWithError(err)
+		done <- err
+	}()
+	select {
+	case meta := <-started:
+		return meta, pw, done
+	case err := <-done:
+		t.Fatalf("upload failed before starting: %v", err)
+	}
+	return nil, nil, nil
+}
+
+func TestUploading(t *testing.T) {
+	g := newTestGob()
+	meta, pw, done := startUpload(t, g, &UploadOptions{})
+	pw.Write([]byte("hello"))
+	// Every process sees it's uploading, not just this one
+	if _, err := g.GetMetadata(meta.ID); err != ErrUploading {
+		t.Fatalf("got %v, want ErrUploading", err)
+	}
+	if _, err := g.GetMetadataBySecret(meta.Secret); err != ErrUploading {
+		t.Fatalf("got %v, want ErrUploading by secret", err)
+	}
+	if _, err := g.Follow(meta.ID); err != ErrNotUploading {
+		t.Fatalf("got %v, want ErrNotUploading for an upload that isn't live", err)
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got, err := download(g, meta.ID, ""); err != nil {
+		t.Fatal(err)
+	} else if got != "hello" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestFollow(t *testing.T) {
+	g := newTestGob()
+	if _, err := g.Upload(strings.NewReader("x"), &UploadOptions{Live: true, Views: 1}); err != ErrLiveNotAllowed {
+		t.Fatalf("got %v, want ErrLiveNotAllowed", err)
+	}
+
+	meta, pw, done := startUpload(t, g, &UploadOptions{Live: true})
+	f, err := g.Follow(meta.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	followed := make(chan string, 1)
+	go func() {
+		buf := &bytes.Buffer{}
+		f.WriteTo(buf)
+		followed <- buf.String()
+	}()
+	for _, s := range []string{"one ", "two ", "three"} {
+		pw.Write([]byte(s))
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := <-followed; got != "one two three" {
+		t.Fatalf("followed %q", got)
+	}
+	// Once it's done it's a gob like any other
+	if _, err := g.Follow(meta.ID); err != ErrNotUploading {
+		t.Fatalf("got %v, want ErrNotUploading once done", err)
+	}
+
+	// Followers of an upload that fails are told
+	meta, pw, done = startUpload(t, g, &UploadOptions{Live: true, MaxSize: 4})
+	if f, err = g.Follow(meta.ID); err != nil {
+		t.Fatal(err)
+	}
+	pw.Write([]byte("too large"))
+	if err := <-done; err != ErrTooLarge {
+		t.Fatalf("got %v, want ErrTooLarge", err)
+	}
+	if _, err := f.WriteTo(&bytes.Buffer{}); err != ErrUploadFailed {
+		t.Fatalf("got %v, want ErrUploadFailed", err)
+	}
+}
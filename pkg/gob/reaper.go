@@ -0,0 +1,156 @@
+package gob
+
+import (
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/levenlabs/errctx"
+	"github.com/levenlabs/go-llog"
+)
+
+// Reaper metrics, served at /debug/vars by expvar
+var (
+	reaperRuns           = expvar.NewInt("gobin_reaper_runs")
+	reaperErrors         = expvar.NewInt("gobin_reaper_errors")
+	reaperGobsDeleted    = expvar.NewInt("gobin_reaper_gobs_deleted")
+	reaperBytesReclaimed = expvar.NewInt("gobin_reaper_bytes_reclaimed")
+	reaperStaleUploads   = expvar.NewInt("gobin_reaper_stale_uploads")
+)
+
+// TombstoneAge is how long the metadata of a deleted gob is kept, so it's
+// known to have been deleted
+const TombstoneAge = 30 * 24 * time.Hour
+
+// Reap deletes the store objects and then the metadata of gobs that have
+// expired, batchSize at a time, until there are none left. Tombstones older
+// than TombstoneAge are deleted too. It returns the number of gobs deleted and
+// the bytes they took up in the store.
+//
+// A gob that fails to delete is left for the next Reap, which stops the
+// current one after that batch so it doesn't keep retrying it.
+func (gob *Gob) Reap(batchSize int) (int, int64, error) {
+	var deleted int
+	var reclaimed int64
+	for {
+		if err := gob.ctx.Err(); err != nil {
+			return deleted, reclaimed, errctx.Mark(err)
+		}
+		metas, err := gob.db.GetExpiredMetadata(time.Now(), batchSize)
+		if err != nil {
+			return deleted, reclaimed, errctx.Mark(fmt.Errorf("failed to get expired metadata: %v", err))
+		}
+		failed := false
+		for _, meta := range metas {
+			freed, err := gob.reapOne(meta)
+			if err != nil {
+				llog.Warn("failed to reap gob", llog.KV{"id": meta.ID}, llog.ErrKV(err))
+				reaperErrors.Add(1)
+				failed = true
+				continue
+			}
+			deleted++
+			reclaimed += freed
+			reaperGobsDeleted.Add(1)
+			reaperBytesReclaimed.Add(freed)
+		}
+		if failed || len(metas) < batchSize {
+			// Their gobs expired with them, so they're gone or left for
+			// the next Reap
+			if n, err := gob.db.DeleteExpiredHordes(time.Now()); err != nil {
+				return deleted, reclaimed, errctx.Mark(fmt.Errorf("failed to delete expired hordes: %v", err))
+			} else if n > 0 {
+				llog.Debug("deleted expired hordes", llog.KV{"hordes": n})
+			}
+			if n, err := gob.db.DeleteTombstones(time.Now().Add(-TombstoneAge)); err != nil {
+				return deleted, reclaimed, errctx.Mark(fmt.Errorf("failed to delete tombstones: %v", err))
+			} else if n > 0 {
+				llog.Debug("deleted tombstones", llog.KV{"tombstones": n})
+			}
+			return deleted, reclaimed, nil
+		}
+	}
+}
+
+// ReapStaleUploads deletes the store objects and then the metadata of gobs
+// still uploading that haven't been touched for staleAfter, since their
+// process must have died, batchSize at a time. It returns the number of gobs
+// deleted. staleAfter has to be well over UploadHeartbeat.
+//
+// Like Reap, one that fails to delete is left for the next run.
+func (gob *Gob) ReapStaleUploads(staleAfter time.Duration, batchSize int) (int, error) {
+	var deleted int
+	for {
+		if err := gob.ctx.Err(); err != nil {
+			return deleted, errctx.Mark(err)
+		}
+		metas, err := gob.db.GetStaleUploads(time.Now().Add(-staleAfter), batchSize)
+		if err != nil {
+			return deleted, errctx.Mark(fmt.Errorf("failed to get stale uploads: %v", err))
+		}
+		failed := false
+		for _, meta := range metas {
+			freed, err := gob.reapOne(meta)
+			if err != nil {
+				llog.Warn("failed to reap stale upload", llog.KV{"id": meta.ID}, llog.ErrKV(err))
+				reaperErrors.Add(1)
+				failed = true
+				continue
+			}
+			deleted++
+			reaperStaleUploads.Add(1)
+			reaperBytesReclaimed.Add(freed)
+		}
+		if failed || len(metas) < batchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// reapOne deletes the objects before the metadata so a failure never leaves
+// an object nothing points to. Expired gobs and uploads can't be appended to,
+// so no part is added meanwhile. An upload still going finds its metadata
+// gone when it's done, and deletes its object. It returns the stored bytes
+// deleted.
+func (gob *Gob) reapOne(meta *db.Metadata) (int64, error) {
+	_, freed, err := gob.deleteObjects(meta)
+	if err != nil {
+		return 0, errctx.Mark(fmt.Errorf("failed to delete store %s: %v", meta.ID, err))
+	}
+	if err := gob.db.DeleteMetadataByID(meta.ID); err != nil {
+		return freed, errctx.Mark(fmt.Errorf("failed to delete %s metadata: %v", meta.ID, err))
+	}
+	return freed, nil
+}
+
+// RunReaper calls Reap and ReapStaleUploads every interval until the Gob's
+// context is done
+func (gob *Gob) RunReaper(interval, staleUpload time.Duration, batchSize int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gob.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reaperRuns.Add(1)
+		deleted, reclaimed, err := gob.Reap(batchSize)
+		if err != nil {
+			reaperErrors.Add(1)
+			llog.Error("gob reaper failed", llog.ErrKV(err))
+		}
+		if deleted > 0 {
+			llog.Info("reaped expired gobs", llog.KV{"gobs": deleted, "bytes": reclaimed})
+		}
+		stale, err := gob.ReapStaleUploads(staleUpload, batchSize)
+		if err != nil {
+			reaperErrors.Add(1)
+			llog.Error("stale upload reaper failed", llog.ErrKV(err))
+		}
+		if stale > 0 {
+			llog.Info("reaped stale uploads", llog.KV{"gobs": stale})
+		}
+	}
+}
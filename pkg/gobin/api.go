@@ -0,0 +1,147 @@
+package gobin
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/kinghrothgar/gobin/pkg/gob"
+	"github.com/kinghrothgar/gobin/pkg/store"
+	"github.com/levenlabs/go-llog"
+)
+
+// APIGob is the JSON of a gob returned by the /api/v1 handlers
+type APIGob struct {
+	ID          string     `json:"id"`
+	Size        int64      `json:"size"`
+	ContentType string     `json:"content_type"`
+	Filename    string     `json:"filename,omitempty"`
+	Encrypted   bool       `json:"encrypted"`
+	CreateDate  time.Time  `json:"create_date"`
+	ExpireDate  *time.Time `json:"expire_date,omitempty"`
+	ViewsLeft   *int64     `json:"views_left,omitempty"`
+	URL         string     `json:"url"`
+	// The secret and the urls made from it are only given to the uploader
+	Secret    string `json:"secret,omitempty"`
+	ExpireURL string `json:"expire_url,omitempty"`
+	DeleteURL string `json:"delete_url,omitempty"`
+}
+
+// newAPIGob returns the APIGob of meta, with the secret if withSecret
+func newAPIGob(r *http.Request, tmpls *Templates, meta *db.Metadata, withSecret bool) *APIGob {
+	baseURL := getScheme(r) + "://" + tmpls.domain
+	g := &APIGob{
+		ID:          meta.ID,
+		Size:        meta.Size,
+		ContentType: meta.ContentType,
+		Filename:    meta.Filename.String,
+		Encrypted:   meta.Encrypted,
+		CreateDate:  meta.CreateDate,
+		URL:         baseURL + "/" + meta.ID,
+	}
+	if meta.ExpireDate.Valid {
+		g.ExpireDate = &meta.ExpireDate.Time
+	}
+	if meta.ViewsLeft.Valid {
+		g.ViewsLeft = &meta.ViewsLeft.Int64
+	}
+	if withSecret {
+		g.Secret = meta.Secret
+		g.ExpireURL = baseURL + "/expire/" + meta.Secret
+		g.DeleteURL = baseURL + "/delete/" + meta.Secret
+	}
+	return g
+}
+
+func returnJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		llog.Warn("failed to write json", llog.ErrKV(err))
+	}
+}
+
+// APICreateGobHandler uploads a gob the same way as PostGobHandler and
+// returns it with its secret
+func APICreateGobHandler(db db.DB, backend store.Backend, tmpls *Templates, limits *Limits) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g := gob.NewGob(r.Context(), db, backend)
+		meta, _, _, err := uploadGob(w, r, g, limits, nil, nil)
+		if err != nil {
+			returnJSONError(w, r, err)
+			return
+		}
+		apiGob := newAPIGob(r, tmpls, meta, true)
+		w.Header().Set("Location", apiGob.URL)
+		returnJSON(w, http.StatusCreated, apiGob)
+		llog.Debug("uploaded gob", llog.KV{"id": meta.ID})
+	})
+}
+
+// APIGetGobHandler returns the gob with the id in the url
+func APIGetGobHandler(db db.DB, backend store.Backend, tmpls *Templates) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g := gob.NewGob(r.Context(), db, backend)
+		meta, err := g.GetMetadata(mux.Vars(r)["id"])
+		if err != nil {
+			returnJSONError(w, r, err)
+			return
+		}
+		returnJSON(w, http.StatusOK, newAPIGob(r, tmpls, meta, false))
+	})
+}
+
+// APIGetGobContentHandler downloads the gob with the id in the url
+func APIGetGobContentHandler(db db.DB, backend store.Backend, downloads *Downloads) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g := gob.NewGob(r.Context(), db, backend)
+		meta, err := g.GetMetadata(mux.Vars(r)["id"])
+		if err != nil {
+			returnJSONError(w, r, err)
+			return
+		}
+		serveGob(w, r, g, meta, downloads, returnJSONError)
+	})
+}
+
+// APIExpireGobHandler expires the gob with the id in the url. The secret is
+// given the same way as to DeleteGobHandler.
+func APIExpireGobHandler(db db.DB, backend store.Backend, tmpls *Templates) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, err := getSecret(r)
+		if err != nil {
+			returnJSONError(w, r, err)
+			return
+		}
+		g := gob.NewGob(r.Context(), db, backend)
+		meta, err := g.ExpireByID(mux.Vars(r)["id"], secret)
+		if err != nil {
+			returnJSONError(w, r, err)
+			return
+		}
+		returnJSON(w, http.StatusOK, newAPIGob(r, tmpls, meta, false))
+		llog.Debug("expired gob", llog.KV{"id": meta.ID})
+	})
+}
+
+// APIDeleteGobHandler deletes the gob with the id in the url. The secret is
+// given the same way as to DeleteGobHandler.
+func APIDeleteGobHandler(db db.DB, backend store.Backend) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, err := getSecret(r)
+		if err != nil {
+			returnJSONError(w, r, err)
+			return
+		}
+		id := mux.Vars(r)["id"]
+		g := gob.NewGob(r.Context(), db, backend)
+		if err := g.DeleteByID(id, secret); err != nil {
+			returnJSONError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		llog.Debug("deleted gob", llog.KV{"id": id})
+	})
+}
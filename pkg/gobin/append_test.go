@@ -0,0 +1,60 @@
+package gobin
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/zstd"
+)
+
+func TestAppendHandler(t *testing.T) {
+	srv := newTestServer(t, &Limits{MaxGobSize: 20})
+	id, secret := srv.upload(t, "/", "hello")
+	for _, s := range []string{" gobin", " world"} {
+		resp, out := srv.do(t, "PUT", "/append/"+secret, strings.NewReader(s), nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %d: %s", resp.StatusCode, out)
+		}
+	}
+	want := "hello gobin world"
+	meta, err := srv.db.GetMetadataByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body := srv.do(t, "GET", "/"+id, nil, map[string]string{"Accept-Encoding": "identity"})
+	if resp.StatusCode != http.StatusOK || body != want {
+		t.Fatalf("got %d %q", resp.StatusCode, body)
+	} else if resp.Header.Get("ETag") != `"`+meta.ContentHash.String+`"` {
+		t.Fatalf("got ETag %q", resp.Header.Get("ETag"))
+	} else if lm := meta.ModifyDate.Time.UTC().Format(http.TimeFormat); resp.Header.Get("Last-Modified") != lm {
+		t.Fatalf("got Last-Modified %q, want the append's %q", resp.Header.Get("Last-Modified"), lm)
+	}
+	// Ranges and compressed downloads span the parts
+	resp, body = srv.do(t, "GET", "/"+id, nil, map[string]string{"Range": "bytes=3-13"})
+	if resp.StatusCode != http.StatusPartialContent || body != "lo gobin wo" {
+		t.Fatalf("got %d %q", resp.StatusCode, body)
+	}
+	resp, body = srv.do(t, "GET", "/"+id, nil, map[string]string{"Accept-Encoding": "zstd"})
+	if resp.Header.Get("Content-Encoding") != "zstd" {
+		t.Fatalf("got Content-Encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+	zr := zstd.NewReader(bytes.NewReader([]byte(body)))
+	got, err := ioutil.ReadAll(zr)
+	zr.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if string(got) != want {
+		t.Fatalf("got %q from zstd", got)
+	}
+
+	if resp, out := srv.do(t, "PUT", "/append/"+secret, strings.NewReader("1234"), nil); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got %d past the max size: %s", resp.StatusCode, out)
+	}
+	if resp, out := srv.do(t, "PUT", "/append/nope", strings.NewReader("more"), nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("got %d for an unknown secret: %s", resp.StatusCode, out)
+	}
+}
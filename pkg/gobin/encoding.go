@@ -0,0 +1,55 @@
+package gobin
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/kinghrothgar/gobin/pkg/gob"
+)
+
+// Downloads are settings of how gobs are served
+type Downloads struct {
+	// Gzip transcodes text gobs to gzip for clients that accept it but not
+	// zstd, trading CPU for egress
+	Gzip bool
+}
+
+// acceptsEncoding is whether the Accept-Encoding header of r allows encoding
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, value := range r.Header["Accept-Encoding"] {
+		for _, part := range strings.Split(value, ",") {
+			params := strings.Split(part, ";")
+			if !strings.EqualFold(strings.TrimSpace(params[0]), encoding) {
+				continue
+			}
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					q, err := strconv.ParseFloat(param[2:], 64)
+					return err == nil && q > 0
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// getEncoding returns how the gob of meta should be encoded for r. Range and
+// conditional requests are always identity so http.ServeContent can answer
+// them.
+func getEncoding(r *http.Request, meta *db.Metadata, downloads *Downloads) string {
+	h := r.Header
+	if h.Get("Range") != "" || h.Get("If-None-Match") != "" || h.Get("If-Modified-Since") != "" {
+		return gob.EncodingIdentity
+	}
+	if acceptsEncoding(r, gob.EncodingZstd) {
+		return gob.EncodingZstd
+	}
+	if downloads.Gzip && textContentTypeReg.MatchString(meta.ContentType) && acceptsEncoding(r, gob.EncodingGzip) {
+		return gob.EncodingGzip
+	}
+	return gob.EncodingIdentity
+}
@@ -0,0 +1,144 @@
+package gobin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/kinghrothgar/gobin/pkg/gob"
+	"github.com/kinghrothgar/gobin/pkg/store"
+	"github.com/levenlabs/errctx"
+	"github.com/levenlabs/go-llog"
+)
+
+// httpError is an error the handlers found themselves, e.g. a bad parameter,
+// with the status and message to give the user
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e *httpError) Error() string {
+	return e.message
+}
+
+func newHTTPError(status int, message string) error {
+	return &httpError{status, message}
+}
+
+func badRequest(message string) error {
+	return newHTTPError(http.StatusBadRequest, message)
+}
+
+// errorStatus returns the status and the message that's safe to show users
+// for err, which is from gob, db, store or an *httpError
+func errorStatus(err error) (int, string) {
+	switch e := errctx.Base(err).(type) {
+	case *httpError:
+		return e.status, e.message
+	case *gob.StoreError:
+		return http.StatusBadGateway, "storage failed, try again later"
+	}
+	switch errctx.Base(err) {
+	case gob.ErrNotFound, db.ErrNotFound, store.ErrNotFound:
+		return http.StatusNotFound, "gob not found"
+	case gob.ErrExpired:
+		return http.StatusGone, "gob expired or was deleted"
+	case gob.ErrDeleted:
+		return http.StatusGone, "gob was deleted"
+	case gob.ErrKeyRequired:
+		return http.StatusBadRequest, "gob is encrypted, the encrypt parameter is required"
+	case gob.ErrBadKey, store.ErrBadKey:
+		return http.StatusForbidden, "wrong encryption key"
+	case gob.ErrWrongSecret:
+		return http.StatusForbidden, "wrong secret"
+	case gob.ErrTooLarge:
+		return http.StatusRequestEntityTooLarge, "gob is too large"
+	case gob.ErrUploading:
+		return http.StatusConflict, "gob is still uploading, add ?follow to watch it"
+	case gob.ErrLiveNotAllowed:
+		return http.StatusBadRequest, "live gobs can't be encrypted or have limited views"
+	case gob.ErrAppendConflict:
+		return http.StatusConflict, "gob was appended to at the same time, try again"
+	case gob.ErrHordeNotFound:
+		return http.StatusNotFound, "horde not found"
+	case gob.ErrHordeExpired:
+		return http.StatusGone, "horde expired"
+	case gob.ErrConflict, db.ErrConflict:
+		return http.StatusConflict, "gob already exists, try again"
+	}
+	return http.StatusInternalServerError, "internal server error"
+}
+
+// jsonError is the JSON error page
+type jsonError struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// errorFunc writes the error page for err
+type errorFunc func(w http.ResponseWriter, r *http.Request, err error)
+
+// logError logs err, as an error only if it's the server's fault
+func logError(r *http.Request, status int, err error) {
+	kv := llog.KV{"status": status, "method": r.Method, "url": r.URL.Path}
+	if status >= 500 {
+		llog.Error("request failed", kv, llog.ErrKV(err))
+	} else {
+		llog.Debug("request failed", kv, llog.ErrKV(err))
+	}
+}
+
+// resetHeaders removes the headers that were set for a gob that's not
+// coming
+func resetHeaders(w http.ResponseWriter) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Disposition")
+	h.Del("ETag")
+	h.Del("Content-Encoding")
+	h.Set("X-Content-Type-Options", "nosniff")
+}
+
+// returnError writes the error page for err as JSON if the client accepts it,
+// otherwise as the TEXT or HTML page for the request
+func returnError(w http.ResponseWriter, r *http.Request, tmpls *Templates, err error) {
+	if wantsJSON(r) {
+		returnJSONError(w, r, err)
+		return
+	}
+	status, message := errorStatus(err)
+	logError(r, status, err)
+	resetHeaders(w)
+	pageType := getPageType(r)
+	h := w.Header()
+	if pageType == "HTML" {
+		h.Set("Content-Type", "text/html; charset=utf-8")
+	} else {
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	pageBytes, tmplErr := tmpls.GetErrorPage(pageType, status, message)
+	if tmplErr != nil {
+		llog.Error("failed to get error page", llog.ErrKV(tmplErr))
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		pageBytes = []byte("Error: " + message + "\n")
+	}
+	w.WriteHeader(status)
+	w.Write(pageBytes)
+}
+
+// returnJSONError writes the JSON error page for err
+func returnJSONError(w http.ResponseWriter, r *http.Request, err error) {
+	status, message := errorStatus(err)
+	logError(r, status, err)
+	resetHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&jsonError{status, message})
+}
+
+// wantsJSON is whether the client asked for JSON with the Accept header
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
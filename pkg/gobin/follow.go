@@ -0,0 +1,122 @@
+package gobin
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/kinghrothgar/gobin/pkg/gob"
+	"github.com/levenlabs/errctx"
+	"github.com/levenlabs/go-llog"
+)
+
+// liveStarted returns the func that sends the follow url of a live upload
+// before its body is read, so it can be shared while it uploads. The upload
+// then has no deadlines, it lasts as long as whatever is piped into it.
+func liveStarted(w http.ResponseWriter, r *http.Request, tmpls *Templates) func(*db.Metadata) {
+	return func(meta *db.Metadata) {
+		rc := http.NewResponseController(w)
+		if err := rc.EnableFullDuplex(); err != nil {
+			llog.Warn("failed to enable full duplex for live upload", llog.KV{"id": meta.ID}, llog.ErrKV(err))
+			return
+		}
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
+		pageBytes, err := tmpls.GetLivePage(getScheme(r), getPageType(r), meta.ID)
+		if err != nil {
+			llog.Warn("failed to get live page", llog.KV{"id": meta.ID}, llog.ErrKV(err))
+			return
+		}
+		w.Write(pageBytes)
+		rc.Flush()
+	}
+}
+
+// flushWriter flushes after every write
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, f.rc.Flush()
+}
+
+// sseWriter writes each write as a Server-Sent Event, whose data lines are
+// joined with newlines by clients to get it back
+type sseWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (s *sseWriter) Write(p []byte) (int, error) {
+	if err := s.event("", p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *sseWriter) event(name string, data []byte) error {
+	buf := &bytes.Buffer{}
+	if name != "" {
+		buf.WriteString("event: " + name + "\n")
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	if _, err := s.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// serveFollow writes the live upload of f, of the gob with id, as it arrives, as Server-Sent Events
+// if the client accepts them and otherwise as plain chunks. SSE ends with an
+// "end" event, or an "error" one if the upload fails, which plain chunks can
+// only show by being cut off.
+func serveFollow(w http.ResponseWriter, r *http.Request, f *gob.Follower, id string) {
+	rc := http.NewResponseController(w)
+	// It lasts as long as the upload
+	rc.SetWriteDeadline(time.Time{})
+	h := w.Header()
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Content-Type-Options", "nosniff")
+	var sse *sseWriter
+	var fw io.Writer
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		h.Set("Content-Type", "text/event-stream")
+		sse = &sseWriter{w, rc}
+		fw = sse
+	} else {
+		// The content type isn't sniffed until the upload is done
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		fw = &flushWriter{w, rc}
+	}
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+	_, err := f.WriteTo(fw)
+	switch {
+	case err == nil && sse != nil:
+		sse.event("end", nil)
+	case errctx.Base(err) == gob.ErrUploadFailed && sse != nil:
+		sse.event("error", []byte(err.Error()))
+	case errctx.Base(err) == gob.ErrUploadFailed:
+		// Aborting leaves the chunked body unterminated, so it's not
+		// mistaken for the whole gob
+		panic(http.ErrAbortHandler)
+	case err != nil:
+		llog.Debug("stopped following gob", llog.KV{"id": id}, llog.ErrKV(err))
+		return
+	}
+	llog.Debug("followed gob", llog.KV{"id": id})
+}
@@ -0,0 +1,78 @@
+package gobin
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kinghrothgar/gobin/pkg/db"
+)
+
+func TestFollow(t *testing.T) {
+	srv := newTestServer(t, nil)
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest("PUT", srv.URL+"/?live", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", "curl/7.64.0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// The follow url comes before the upload is done
+	br := bufio.NewReader(resp.Body)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	followURL := strings.TrimSpace(line)
+	id := followURL[strings.LastIndex(followURL, "/")+1 : strings.Index(followURL, "?")]
+
+	if code, out := srv.get(t, "/"+id); code != http.StatusConflict {
+		t.Fatalf("got %d for an uploading gob: %s", code, out)
+	}
+	followResp, err := http.Get(srv.URL + "/" + id + "?follow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer followResp.Body.Close()
+	if followResp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d following", followResp.StatusCode)
+	}
+	pw.Write([]byte("hello "))
+	pw.Write([]byte("gobin"))
+	pw.Close()
+	if got, err := ioutil.ReadAll(followResp.Body); err != nil {
+		t.Fatal(err)
+	} else if string(got) != "hello gobin" {
+		t.Fatalf("followed %q", got)
+	}
+	if out, err := ioutil.ReadAll(br); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload got %d %q: %v", resp.StatusCode, out, err)
+	}
+	if code, out := srv.get(t, "/"+id); code != http.StatusOK || out != "hello gobin" {
+		t.Fatalf("got %d %q once uploaded", code, out)
+	}
+}
+
+func TestUploadingElsewhere(t *testing.T) {
+	srv := newTestServer(t, nil)
+	// What another instance's upload looks like from this one
+	meta, err := db.NewInsertedMetadata(srv.db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/" + meta.ID, "/" + meta.ID + "?follow", "/api/v1/gobs/" + meta.ID} {
+		if code, out := srv.get(t, path); code != http.StatusConflict {
+			t.Fatalf("%s got %d: %s", path, code, out)
+		}
+	}
+	if resp, out := srv.do(t, "PUT", "/append/"+meta.Secret, strings.NewReader("more"), nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("append got %d: %s", resp.StatusCode, out)
+	}
+}
@@ -1,13 +1,24 @@
 package gobin
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/kinghrothgar/gobin/pkg/db"
 	"github.com/kinghrothgar/gobin/pkg/gob"
+	"github.com/kinghrothgar/gobin/pkg/store"
+	"github.com/levenlabs/errctx"
 	"github.com/levenlabs/go-llog"
 )
 
@@ -19,54 +30,301 @@ var (
 	textContentTypeReg  = regexp.MustCompile("^text/")
 )
 
-func GetRootHandler(db *db.DB, tmpls *Templates) http.Handler {
+// Limits on what users can do
+type Limits struct {
+	// MaxGobSize is the largest gob that can be uploaded in bytes, 0 is no limit
+	MaxGobSize int64
+	// DefaultTTL is how long gobs live when the uploader doesn't say, 0 is
+	// forever
+	DefaultTTL time.Duration
+	// MaxTTL is the longest gobs can live, 0 is no limit
+	MaxTTL time.Duration
+	// Quota of bytes each client can upload a day, nil is no limit
+	Quota *Quota
+}
+
+func GetRootHandler(db db.DB, tmpls *Templates) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		llog.Debug("GetRootHandler called with header", llog.KV{"header": r.Header, "host": r.Host, "requestURI": r.RequestURI, "remoteAddr": r.RemoteAddr})
 		pageType := getPageType(r)
 		pageBytes, err := tmpls.GetHomePage(pageType)
 		if err != nil {
-			llog.Error("failed to get home", llog.ErrKV(err))
-			returnHTTPInternalError(w, "failed to get home")
+			returnError(w, r, tmpls, err)
 			return
 		}
 		w.Write(pageBytes)
 	})
 }
 
-// TODO investigate whether curl loads file into memory when using @ or @-
-func PostGobHandler(db *db.DB, tmpls *Templates) http.Handler {
+// GetFormHandler serves the browser upload form, which posts to
+// PostGobHandler
+func GetFormHandler(tmpls *Templates) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, gobHeader, err := r.FormFile("g")
+		pageBytes, err := tmpls.GetFormPage(getScheme(r))
 		if err != nil {
-			llog.Debug("failed to get form file gob", llog.KV{"err": err})
-			returnHTTPBadRequest(w, "request must have form file 'gob'")
+			returnError(w, r, tmpls, err)
 			return
 		}
-		filename := gobHeader.Filename
-		if fn := r.FormValue("f"); fn != "" {
-			filename = fn
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(pageBytes)
+	})
+}
+
+// maxFieldSize is the most bytes an upload form field other than the gob can
+// have
+const maxFieldSize = 4 << 10
+
+// uploadGob uploads the gob of an upload request with g, and returns its
+// parameters. The gob is either the form file "g" of a multipart request, or
+// the raw body of anything else. Either way it's streamed to the store rather
+// than buffered. check, if it's not nil, is called with the parameters known
+// before the gob is uploaded, so bad ones fail without uploading it. The
+// quotaReader it was counted with, nil without a quota, is for cleanUpUpload.
+func uploadGob(w http.ResponseWriter, r *http.Request, g *gob.Gob, limits *Limits, check func(url.Values) error, started func(*db.Metadata)) (*db.Metadata, url.Values, *quotaReader, error) {
+	if limits.Quota != nil && limits.Quota.Remaining(limits.Quota.client(r)) <= 0 {
+		return nil, nil, nil, errQuotaExceeded
+	}
+	var meta *db.Metadata
+	var params url.Values
+	var qr *quotaReader
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		meta, params, qr, err = uploadMultipart(w, r, g, limits, check)
+	} else {
+		meta, params, qr, err = uploadRaw(r, g, limits, check, started)
+	}
+	return meta, params, qr, tooLargeError(err, limits)
+}
+
+// tooLargeError gives gob.ErrTooLarge the size limit, for the user
+func tooLargeError(err error, limits *Limits) error {
+	if errctx.Base(err) == gob.ErrTooLarge && limits.MaxGobSize > 0 {
+		msg := fmt.Sprintf("gob is larger than the limit of %d bytes", limits.MaxGobSize)
+		return newHTTPError(http.StatusRequestEntityTooLarge, msg)
+	}
+	return err
+}
+
+// upload is g.Upload counting reader against the client's quota, with the
+// quotaReader it was counted with, nil without a quota
+func upload(r *http.Request, g *gob.Gob, reader io.Reader, opts *gob.UploadOptions, limits *Limits) (*db.Metadata, *quotaReader, error) {
+	if limits.Quota == nil {
+		meta, err := g.Upload(reader, opts)
+		return meta, nil, err
+	}
+	qr := &quotaReader{r: reader, quota: limits.Quota, client: limits.Quota.client(r)}
+	meta, err := g.Upload(qr, opts)
+	if err != nil {
+		// Nothing was kept
+		qr.giveBack()
+		return nil, nil, err
+	}
+	return meta, qr, nil
+}
+
+// uploadRaw uploads the body of r. started is called for live uploads once
+// the gob has its id, if it's not nil.
+func uploadRaw(r *http.Request, g *gob.Gob, limits *Limits, check func(url.Values) error, started func(*db.Metadata)) (*db.Metadata, url.Values, *quotaReader, error) {
+	// Upload and the quota check the size too, this just fails early
+	if limits.MaxGobSize > 0 && r.ContentLength > limits.MaxGobSize {
+		return nil, nil, nil, gob.ErrTooLarge
+	}
+	if limits.Quota != nil && r.ContentLength > limits.Quota.Remaining(limits.Quota.client(r)) {
+		return nil, nil, nil, errQuotaExceeded
+	}
+	// Options can only be in the query since the body is the gob
+	params := r.URL.Query()
+	filename := params.Get("f")
+	if fn := mux.Vars(r)["filename"]; fn != "" && filename == "" {
+		filename = fn
+	}
+	opts, err := getUploadOptions(params, filename, limits)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if check != nil {
+		if err := check(params); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if opts.Live {
+		opts.Started = started
+	}
+	llog.Debug("got raw upload", llog.KV{"filename": filename, "size": r.ContentLength})
+	meta, qr, err := upload(r, g, r.Body, opts, limits)
+	return meta, params, qr, err
+}
+
+// uploadMultipart uploads the form file "g" as its part is read. Fields after
+// it are applied with SetOptions once the rest of the form is read, except
+// encrypt which has to be known before. check is only given the fields before
+// it.
+func uploadMultipart(w http.ResponseWriter, r *http.Request, g *gob.Gob, limits *Limits, check func(url.Values) error) (*db.Metadata, url.Values, *quotaReader, error) {
+	if limits.MaxGobSize > 0 {
+		// The gob is checked exactly by Upload, this is slack for the rest of
+		// the form
+		r.Body = http.MaxBytesReader(w, r.Body, limits.MaxGobSize+1<<20)
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, nil, badRequest("invalid multipart form")
+	}
+	params := r.URL.Query()
+	var meta *db.Metadata
+	var opts *gob.UploadOptions
+	var qr *quotaReader
+	fieldsAfter := false
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if isBodyTooLarge(err) {
+			return nil, nil, nil, cleanUpUpload(g, meta, qr, gob.ErrTooLarge)
+		} else if err != nil {
+			return nil, nil, nil, cleanUpUpload(g, meta, qr, badRequest("invalid multipart form"))
+		}
+		name := part.FormName()
+		// Forms send empty fields for the file and text inputs not used
+		body := bufio.NewReader(part)
+		if _, err := body.Peek(1); name == "g" && err == io.EOF {
+			continue
 		}
-		// TODO return error if gobHeader.Size too big
-		llog.Debug("got file upload", llog.KV{"filename": filename, "size": gobHeader.Size})
-		gobFile, err := gobHeader.Open()
+		if name == "g" && meta != nil {
+			return nil, nil, nil, cleanUpUpload(g, meta, qr, badRequest("request can only have one form file 'g'"))
+		} else if name == "g" {
+			filename := params.Get("f")
+			if filename == "" {
+				filename = part.FileName()
+			}
+			if opts, err = getUploadOptions(params, filename, limits); err != nil {
+				return nil, nil, nil, err
+			}
+			if check != nil {
+				if err := check(params); err != nil {
+					return nil, nil, nil, err
+				}
+			}
+			llog.Debug("got file upload", llog.KV{"filename": filename})
+			if meta, qr, err = upload(r, g, body, opts, limits); err != nil {
+				return nil, nil, nil, err
+			}
+			continue
+		}
+		value, err := ioutil.ReadAll(io.LimitReader(body, maxFieldSize+1))
+		if isBodyTooLarge(err) {
+			return nil, nil, nil, cleanUpUpload(g, meta, qr, gob.ErrTooLarge)
+		} else if err != nil {
+			return nil, nil, nil, cleanUpUpload(g, meta, qr, badRequest("invalid multipart form"))
+		} else if len(value) > maxFieldSize {
+			return nil, nil, nil, cleanUpUpload(g, meta, qr, badRequest("form field '"+name+"' is too large"))
+		}
+		params.Add(name, string(value))
+		fieldsAfter = meta != nil
+	}
+	if meta == nil {
+		return nil, nil, nil, badRequest("request must have form file 'g'")
+	}
+	if !fieldsAfter {
+		return meta, params, qr, nil
+	}
+	filename := params.Get("f")
+	if filename == "" {
+		filename = opts.Filename
+	}
+	newOpts, err := getUploadOptions(params, filename, limits)
+	if err != nil {
+		return nil, nil, nil, cleanUpUpload(g, meta, qr, err)
+	}
+	if newOpts.EncryptKey != opts.EncryptKey {
+		return nil, nil, nil, cleanUpUpload(g, meta, qr, badRequest("encrypt must come before form file 'g'"))
+	}
+	if err := g.SetOptions(meta, newOpts); err != nil {
+		return nil, nil, nil, cleanUpUpload(g, meta, qr, err)
+	}
+	return meta, params, qr, nil
+}
+
+// isBodyTooLarge is whether err is from reading past a http.MaxBytesReader
+func isBodyTooLarge(err error) bool {
+	return errors.As(err, new(*http.MaxBytesError))
+}
+
+// cleanUpUpload deletes the gob of a failed upload, if it got that far, gives
+// back the quota it was counted against with qr, and returns err
+func cleanUpUpload(g *gob.Gob, meta *db.Metadata, qr *quotaReader, err error) error {
+	if meta == nil {
+		return err
+	}
+	qr.giveBack()
+	if delErr := g.Delete(meta.Secret); delErr != nil {
+		llog.Warn("failed to delete gob of failed upload", llog.KV{"id": meta.ID}, llog.ErrKV(delErr))
+	}
+	return err
+}
+
+// getUploadOptions returns the options of an upload with params
+func getUploadOptions(params url.Values, filename string, limits *Limits) (*gob.UploadOptions, error) {
+	expireDate, err := getExpireDate(params, limits)
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+	views, err := getViews(params)
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+	_, live := params["live"]
+	return &gob.UploadOptions{
+		EncryptKey: params.Get("encrypt"),
+		Filename:   filename,
+		ExpireDate: expireDate,
+		Views:      views,
+		MaxSize:    limits.MaxGobSize,
+		Live:       live,
+	}, nil
+}
+
+// PostGobHandler uploads a gob, into the horde in the url or the "horde"
+// parameter if there is one
+// TODO investigate whether curl loads file into memory when using @ or @-
+func PostGobHandler(db db.DB, backend store.Backend, tmpls *Templates, limits *Limits) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g := gob.NewGob(r.Context(), db, backend)
+		ww := &writtenWriter{ResponseWriter: w}
+		fail := func(err error) {
+			if !ww.written {
+				returnError(w, r, tmpls, err)
+				return
+			}
+			// The follow url of a live upload was sent, the error can only
+			// follow it
+			status, message := errorStatus(err)
+			logError(r, status, err)
+			fmt.Fprintf(w, "Error: %s\n", message)
+		}
+		check := func(params url.Values) error {
+			return checkHorde(r, g, params, limits)
+		}
+		meta, params, qr, err := uploadGob(w, r, g, limits, check, liveStarted(ww, r, tmpls))
 		if err != nil {
-			llog.Fatal("failed to open file gob", llog.KV{"err": err})
+			fail(err)
+			return
 		}
-		defer gobFile.Close()
-		encryptKey := r.URL.Query().Get("encrypt")
-		gob := gob.NewGob(r.Context(), db)
-		meta, err := gob.Upload(gobFile, encryptKey, filename)
+		horde, hordeSecret, err := addToHorde(r, g, meta, params, limits)
 		if err != nil {
-			llog.Error("failed to upload gob", llog.KV{"err": err})
-			returnHTTPInternalError(w, "failed to upload gob")
+			fail(cleanUpUpload(g, meta, qr, err))
 			return
 		}
+		// Link to the view in the language asked for, e.g. from the form
+		lang := params.Get("lang")
+		if !alphaNumericReg.MatchString(lang) {
+			lang = ""
+		}
 		pageType := getPageType(r)
-		pageBytes, err := tmpls.GetURLPage(getScheme(r), pageType, meta.ID, meta.Secret)
+		pageBytes, err := tmpls.GetURLPage(getScheme(r), pageType, meta.ID, meta.Secret, lang, horde, hordeSecret)
 		// TODO should delete gob if we can't tell users the id
 		if err != nil {
-			llog.Error("failed to upload gob", llog.KV{"err": err})
-			returnHTTPInternalError(w, "failed to upload gob")
+			fail(err)
 			return
 		}
 		w.Write(pageBytes)
@@ -74,81 +332,383 @@ func PostGobHandler(db *db.DB, tmpls *Templates) http.Handler {
 	})
 }
 
-// TODO investigate whether curl loads file into memory when using @ or @-
-// TODO validate gob id
-func GetGobHandler(db *db.DB) http.Handler {
+// AppendGobHandler appends the body to the gob with the secret in the url, so
+// e.g. a long running job can stream its output into one gob
+func AppendGobHandler(db db.DB, backend store.Backend, tmpls *Templates, limits *Limits) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id, ok := vars["id"]
-		if !ok {
-			llog.Debug("failed to get id in GetGobHandler")
-			returnHTTPBadRequest(w, "request must have gob id in url")
-			return
-		}
-		// TODO validate id
-		encryptKey := r.URL.Query().Get("encrypt")
-		gob := gob.NewGob(r.Context(), db)
-		meta, err := gob.GetMetadata(id)
-		// TODO figure out if it was user error
+		if limits.Quota != nil && limits.Quota.Remaining(limits.Quota.client(r)) <= 0 {
+			returnError(w, r, tmpls, errQuotaExceeded)
+			return
+		}
+		var reader io.Reader = r.Body
+		var qr *quotaReader
+		if limits.Quota != nil {
+			qr = &quotaReader{r: reader, quota: limits.Quota, client: limits.Quota.client(r)}
+			reader = qr
+		}
+		g := gob.NewGob(r.Context(), db, backend)
+		secret := mux.Vars(r)["secret"]
+		meta, n, err := g.Append(secret, reader, r.URL.Query().Get("encrypt"), limits.MaxGobSize)
 		if err != nil {
-			returnHTTPNotFound(w, id+" gob not found")
+			// Nothing was kept
+			qr.giveBack()
+			returnError(w, r, tmpls, tooLargeError(err, limits))
+			return
 		}
-		// TODO will cause download in browser
-		//if meta.Filename.Valid {
-		//	w.Header().Set("Content-Disposition", "attachment; filename="+meta.Filename.String)
-		//}
-		w.Header().Set("Content-Type", meta.ContentType)
-		w.Header().Set("Content-Length", strconv.FormatInt(meta.Size, 10))
-		// TODO figure out if it was user error
-		if err = gob.Download(w, meta, encryptKey); err != nil {
-			llog.Error("failed to download gob", llog.KV{"err": err})
-			returnHTTPInternalError(w, "failed to download gob")
+		pageBytes, err := tmpls.GetMessPage(getPageType(r), fmt.Sprintf("appended %d bytes to %s", n, meta.ID))
+		if err != nil {
+			returnError(w, r, tmpls, err)
 			return
 		}
-		llog.Debug("downloaded gob", llog.KV{"id": meta.ID})
+		w.Write(pageBytes)
+		llog.Debug("appended to gob", llog.KV{"id": meta.ID, "size": n})
 	})
 }
 
-func GetExpireHandler(db *db.DB, tmpls *Templates) http.Handler {
+// writtenWriter remembers if anything was written, after which it's too late
+// for an error page
+type writtenWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+// Unwrap is for http.ResponseController
+func (w *writtenWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *writtenWriter) Write(p []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(p)
+}
+
+// serveGob writes the gob of meta to w, calling returnErr if it can't
+func serveGob(w http.ResponseWriter, r *http.Request, g *gob.Gob, meta *db.Metadata, downloads *Downloads, returnErr errorFunc) {
+	encryptKey := r.URL.Query().Get("encrypt")
+	// TODO will cause download in browser
+	//if meta.Filename.Valid {
+	//	w.Header().Set("Content-Disposition", "attachment; filename="+meta.Filename.String)
+	//}
+	h := w.Header()
+	h.Set("Content-Type", meta.ContentType)
+	h.Set("Vary", "Accept-Encoding")
+	encoding := getEncoding(r, meta, downloads)
+	// Encrypted gobs have no ETag so the hash can't be used to guess them
+	if meta.ContentHash.Valid && !meta.Encrypted {
+		etag := `"` + meta.ContentHash.String + `"`
+		if encoding != gob.EncodingIdentity {
+			// Other encodings have other bytes, so the ETag is only weak
+			etag = "W/" + etag
+		}
+		h.Set("ETag", etag)
+	}
+	if encoding == gob.EncodingIdentity && !meta.ViewsLeft.Valid {
+		serveContent(w, r, g, meta, encryptKey, returnErr)
+		return
+	}
+	// Gobs with limited views are only served whole, so a view is a whole
+	// download
+	if encoding == gob.EncodingIdentity {
+		h.Set("Content-Length", strconv.FormatInt(meta.Size, 10))
+	} else {
+		h.Set("Content-Encoding", encoding)
+	}
+	// Downloading for a HEAD would use up a view for nothing
+	if r.Method == "HEAD" {
+		return
+	}
+	ww := &writtenWriter{ResponseWriter: w}
+	if err := g.DownloadEncoded(ww, meta, encryptKey, encoding); err != nil {
+		if ww.written {
+			llog.Error("failed to download gob", llog.KV{"id": meta.ID}, llog.ErrKV(err))
+			return
+		}
+		returnErr(w, r, err)
+		return
+	}
+	llog.Debug("downloaded gob", llog.KV{"id": meta.ID})
+}
+
+// serveContent writes the gob of meta with http.ServeContent, which handles
+// Range and conditional requests
+func serveContent(w http.ResponseWriter, r *http.Request, g *gob.Gob, meta *db.Metadata, encryptKey string, returnErr errorFunc) {
+	content, err := g.OpenContent(meta, encryptKey)
+	if err != nil {
+		returnErr(w, r, err)
+		return
+	}
+	defer content.Close()
+	http.ServeContent(w, r, "", meta.LastModified(), content)
+	if err := content.Err(); err != nil {
+		llog.Error("failed to download gob", llog.KV{"id": meta.ID}, llog.ErrKV(err))
+		return
+	}
+	llog.Debug("downloaded gob", llog.KV{"id": meta.ID})
+}
+
+// TODO investigate whether curl loads file into memory when using @ or @-
+func GetGobHandler(db db.DB, backend store.Backend, tmpls *Templates, downloads *Downloads) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		secret, ok := vars["secret"]
-		if !ok {
-			llog.Error("failed to get secret in GetExpireHandler")
-			returnHTTPBadRequest(w, "expire request must have gob secret in url")
+		returnErr := func(w http.ResponseWriter, r *http.Request, err error) {
+			returnError(w, r, tmpls, err)
+		}
+		g := gob.NewGob(r.Context(), db, backend)
+		id := mux.Vars(r)["id"]
+		meta, err := g.GetMetadata(id)
+		if err == gob.ErrUploading {
+			// Only live uploads in this process can be followed
+			if _, follow := r.URL.Query()["follow"]; follow {
+				if f, err := g.Follow(id); err == nil {
+					serveFollow(w, r, f, id)
+					return
+				}
+			}
+			returnErr(w, r, err)
+			return
+		} else if err != nil {
+			returnErr(w, r, err)
 			return
 		}
-		// TODO validate id
-		gob := gob.NewGob(r.Context(), db)
-		meta, err := gob.Expire(secret)
-		// TODO figure out if it was user error
-		if err != nil {
-			llog.Warn("failed to expire gob", llog.KV{"err": err})
-			returnHTTPInternalError(w, "failed to expire gob")
+		// Link previews look like browsers too, so browsers confirm before
+		// a view is taken
+		if _, view := r.URL.Query()["view"]; meta.ViewsLeft.Valid && !view && getPageType(r) == "HTML" {
+			serveViewPage(w, r, tmpls, meta)
+			return
+		}
+		// Rendered pages download the gob, so a HEAD only gets its headers
+		if r.Method == "HEAD" && meta.ViewsLeft.Valid {
+			serveGob(w, r, g, meta, downloads, returnErr)
+			return
+		}
+		if isMarkdown(r, meta) {
+			serveMarkdown(w, r, g, tmpls, meta)
+			return
+		}
+		if lang, ok := getHighlightLang(r, meta); ok {
+			serveHighlighted(w, r, g, tmpls, meta, lang)
 			return
 		}
+		serveGob(w, r, g, meta, downloads, returnErr)
+	})
+}
+
+// serveViewPage asks to confirm taking one of the limited views of the gob of
+// meta
+func serveViewPage(w http.ResponseWriter, r *http.Request, tmpls *Templates, meta *db.Metadata) {
+	pageBytes, err := tmpls.GetViewPage(getTitle(meta), meta.ViewsLeft.Int64, r.URL.Path, r.URL.Query())
+	if err != nil {
+		returnError(w, r, tmpls, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(pageBytes)
+}
 
+// getHighlightLang returns the language to highlight the gob of meta in, from
+// the url as /{id}.{lang} or ?lang=, and whether it should be. Text gobs are
+// highlighted for browsers in the language they're detected as, unless raw is
+// given.
+func getHighlightLang(r *http.Request, meta *db.Metadata) (string, bool) {
+	if meta.Size > maxRenderSize {
+		return "", false
+	}
+	if lang := getLang(r); lang != "" {
+		return lang, true
+	}
+	_, raw := r.URL.Query()["raw"]
+	return "", !raw && getPageType(r) == "HTML" && textContentTypeReg.MatchString(meta.ContentType)
+}
+
+// getLang returns the language a gob was asked for in, from the url as
+// /{id}.{lang} or ?lang=
+func getLang(r *http.Request) string {
+	if lang := mux.Vars(r)["lang"]; lang != "" {
+		return lang
+	}
+	return r.URL.Query().Get("lang")
+}
+
+// getTitle returns the title of pages showing the gob of meta
+func getTitle(meta *db.Metadata) string {
+	if meta.Filename.Valid {
+		return meta.Filename.String
+	}
+	return meta.ID
+}
+
+// downloadToRender returns the gob of meta to be rendered as HTML
+func downloadToRender(r *http.Request, g *gob.Gob, meta *db.Metadata) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := g.Download(buf, meta, r.URL.Query().Get("encrypt")); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// serveHighlighted writes the gob of meta as highlighted HTML
+func serveHighlighted(w http.ResponseWriter, r *http.Request, g *gob.Gob, tmpls *Templates, meta *db.Metadata, lang string) {
+	source, err := downloadToRender(r, g, meta)
+	if err != nil {
+		returnError(w, r, tmpls, err)
+		return
+	}
+	data, language, err := highlight(lang, meta, source)
+	if err != nil {
+		returnError(w, r, tmpls, err)
+		return
+	}
+	pageBytes, err := tmpls.GetGobPage(getTitle(meta), language, data)
+	if err != nil {
+		returnError(w, r, tmpls, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(pageBytes)
+	llog.Debug("downloaded highlighted gob", llog.KV{"id": meta.ID, "language": language})
+}
+
+// serveMarkdown writes the gob of meta rendered as markdown
+func serveMarkdown(w http.ResponseWriter, r *http.Request, g *gob.Gob, tmpls *Templates, meta *db.Metadata) {
+	source, err := downloadToRender(r, g, meta)
+	if err != nil {
+		returnError(w, r, tmpls, err)
+		return
+	}
+	data, toc, err := renderMarkdown(source)
+	if err != nil {
+		returnError(w, r, tmpls, err)
+		return
+	}
+	pageBytes, err := tmpls.GetMDPage(getTitle(meta), toc, data)
+	if err != nil {
+		returnError(w, r, tmpls, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(pageBytes)
+	llog.Debug("downloaded rendered markdown gob", llog.KV{"id": meta.ID})
+}
+
+func GetExpireHandler(db db.DB, backend store.Backend, tmpls *Templates) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := mux.Vars(r)["secret"]
+		g := gob.NewGob(r.Context(), db, backend)
+		meta, err := g.Expire(secret)
+		if err != nil {
+			returnError(w, r, tmpls, err)
+			return
+		}
 		pageType := getPageType(r)
 		pageBytes, err := tmpls.GetMessPage(pageType, "successfully deleted "+meta.ID)
+		if err != nil {
+			returnError(w, r, tmpls, err)
+			return
+		}
 		w.Write(pageBytes)
 		llog.Debug("expired gob", llog.KV{"id": meta.ID})
 	})
 }
 
-func returnHTTPError(w http.ResponseWriter, message string, status int) {
-	http.Error(w, "Error: "+message, status)
+// DeleteGobHandler deletes the gob with the id in the url. The secret is
+// given with the "secret" query parameter or the X-Gob-Secret header.
+func DeleteGobHandler(db db.DB, backend store.Backend, tmpls *Templates) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		secret, err := getSecret(r)
+		if err != nil {
+			returnError(w, r, tmpls, err)
+			return
+		}
+		g := gob.NewGob(r.Context(), db, backend)
+		if err := g.DeleteByID(id, secret); err != nil {
+			returnError(w, r, tmpls, err)
+			return
+		}
+		pageBytes, err := tmpls.GetMessPage(getPageType(r), "successfully deleted "+id)
+		if err != nil {
+			returnError(w, r, tmpls, err)
+			return
+		}
+		w.Write(pageBytes)
+		llog.Debug("deleted gob", llog.KV{"id": id})
+	})
+}
+
+// GetDeleteHandler asks the user to confirm deleting the gob with the secret
+// in the url, which PostDeleteHandler then does
+func GetDeleteHandler(db db.DB, backend store.Backend, tmpls *Templates) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := mux.Vars(r)["secret"]
+		g := gob.NewGob(r.Context(), db, backend)
+		meta, err := g.GetMetadataBySecret(secret)
+		if err != nil {
+			returnError(w, r, tmpls, err)
+			return
+		}
+		pageBytes, err := tmpls.GetDeletePage(getScheme(r), getPageType(r), meta.ID, secret)
+		if err != nil {
+			returnError(w, r, tmpls, err)
+			return
+		}
+		w.Write(pageBytes)
+	})
 }
 
-func returnHTTPInternalError(w http.ResponseWriter, message string) {
-	http.Error(w, "Error: "+message, http.StatusInternalServerError)
+// PostDeleteHandler deletes the gob with the secret in the url
+func PostDeleteHandler(db db.DB, backend store.Backend, tmpls *Templates) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := mux.Vars(r)["secret"]
+		g := gob.NewGob(r.Context(), db, backend)
+		meta, err := g.GetMetadataBySecret(secret)
+		if err == nil {
+			err = g.Delete(secret)
+		}
+		if err != nil {
+			returnError(w, r, tmpls, err)
+			return
+		}
+		pageBytes, err := tmpls.GetMessPage(getPageType(r), "successfully deleted "+meta.ID)
+		if err != nil {
+			returnError(w, r, tmpls, err)
+			return
+		}
+		w.Write(pageBytes)
+		llog.Debug("deleted gob", llog.KV{"id": meta.ID})
+	})
 }
 
-func returnHTTPNotFound(w http.ResponseWriter, message string) {
-	http.Error(w, "Error: "+message, http.StatusNotFound)
+// getSecret returns the secret of a request by id, from the "secret" query
+// parameter or the X-Gob-Secret header
+func getSecret(r *http.Request) (string, error) {
+	secret := r.URL.Query().Get("secret")
+	if secret == "" {
+		secret = r.Header.Get("X-Gob-Secret")
+	}
+	if secret == "" {
+		return "", badRequest("request must have the gob secret")
+	}
+	return secret, nil
 }
 
-func returnHTTPBadRequest(w http.ResponseWriter, message string) {
-	http.Error(w, "Error: "+message, http.StatusBadRequest)
+// getViews returns how many times an uploaded gob can be downloaded, from the
+// "views" upload parameter, or 1 if "burn" is given. 0 is unlimited.
+func getViews(params url.Values) (int64, error) {
+	viewsStr := params.Get("views")
+	if _, burn := params["burn"]; burn {
+		if viewsStr != "" {
+			return 0, errors.New("only one of views and burn can be given")
+		}
+		return 1, nil
+	}
+	if viewsStr == "" {
+		return 0, nil
+	}
+	views, err := strconv.ParseInt(viewsStr, 10, 64)
+	if err != nil || views <= 0 {
+		return 0, fmt.Errorf("invalid views %q, must be a positive number", viewsStr)
+	}
+	return views, nil
 }
 
 func getScheme(r *http.Request) (scheme string) {
@@ -0,0 +1,474 @@
+package gobin
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/kinghrothgar/gobin/pkg/store"
+)
+
+type testServer struct {
+	*httptest.Server
+	db    *db.MemoryDB
+	store *store.MemoryBackend
+}
+
+// newTestServer serves the same routes as cmd/gobin on the in-memory db and
+// store
+func newTestServer(t *testing.T, limits *Limits) *testServer {
+	t.Helper()
+	tmpls, err := NewTemplates("../../templates/htmlTemplates.tmpl", "../../templates/textTemplates.tmpl", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits == nil {
+		limits = &Limits{}
+	}
+	mdb := db.NewMemoryDB()
+	backend := store.NewMemoryBackend()
+	downloads := &Downloads{Gzip: true}
+	r := mux.NewRouter()
+	r.Handle("/", GetRootHandler(mdb, tmpls)).Methods("GET")
+	r.Handle("/", PostGobHandler(mdb, backend, tmpls, limits)).Methods("POST", "PUT")
+	r.Handle("/new/gob", GetFormHandler(tmpls)).Methods("GET")
+	r.Handle("/{filename}", PostGobHandler(mdb, backend, tmpls, limits)).Methods("PUT")
+	r.Handle("/{id:[a-zA-Z0-9]+}", GetGobHandler(mdb, backend, tmpls, downloads)).Methods("GET", "HEAD")
+	r.Handle("/{id:[a-zA-Z0-9]+}.{lang}", GetGobHandler(mdb, backend, tmpls, downloads)).Methods("GET", "HEAD")
+	r.Handle("/{id:[a-zA-Z0-9]+}", DeleteGobHandler(mdb, backend, tmpls)).Methods("DELETE")
+	r.Handle("/append/{secret}", AppendGobHandler(mdb, backend, tmpls, limits)).Methods("POST", "PUT")
+	r.Handle("/expire/{secret}", GetExpireHandler(mdb, backend, tmpls)).Methods("GET")
+	r.Handle("/delete/{secret}", GetDeleteHandler(mdb, backend, tmpls)).Methods("GET")
+	r.Handle("/delete/{secret}", PostDeleteHandler(mdb, backend, tmpls)).Methods("POST")
+	r.Handle("/{horde:[a-zA-Z0-9_-]+}", PostGobHandler(mdb, backend, tmpls, limits)).Methods("POST")
+	r.Handle("/horde/{horde:[a-zA-Z0-9_-]+}", GetHordeHandler(mdb, backend, tmpls)).Methods("GET")
+	r.Handle("/horde/{horde:[a-zA-Z0-9_-]+}.{format:tar|zip}", GetHordeArchiveHandler(mdb, backend, tmpls)).Methods("GET")
+	r.Handle("/horde/expire/{secret}", GetHordeExpireHandler(mdb, backend, tmpls)).Methods("GET")
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.Handle("/gobs", APICreateGobHandler(mdb, backend, tmpls, limits)).Methods("POST")
+	api.Handle("/gobs/{id:[a-zA-Z0-9]+}", APIGetGobHandler(mdb, backend, tmpls)).Methods("GET")
+	api.Handle("/gobs/{id:[a-zA-Z0-9]+}", APIDeleteGobHandler(mdb, backend)).Methods("DELETE")
+	api.Handle("/gobs/{id:[a-zA-Z0-9]+}/content", APIGetGobContentHandler(mdb, backend, downloads)).Methods("GET", "HEAD")
+	api.Handle("/gobs/{id:[a-zA-Z0-9]+}/expire", APIExpireGobHandler(mdb, backend, tmpls)).Methods("POST")
+	srv := &testServer{httptest.NewServer(r), mdb, backend}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// do sends a request with the headers in hdr, as curl unless hdr says
+// otherwise, and returns the response with its body read
+func (s *testServer) do(t *testing.T, method, path string, body io.Reader, hdr map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, s.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", "curl/7.64.0")
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(out)
+}
+
+func (s *testServer) get(t *testing.T, path string) (int, string) {
+	t.Helper()
+	resp, body := s.do(t, "GET", path, nil, nil)
+	return resp.StatusCode, body
+}
+
+// form is a multipart form of fields, which are name then value, followed by
+// a g file field of content
+func form(content string, fields ...string) (io.Reader, string) {
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	for i := 0; i+1 < len(fields); i += 2 {
+		mw.WriteField(fields[i], fields[i+1])
+	}
+	fw, _ := mw.CreateFormFile("g", "hello.txt")
+	fw.Write([]byte(content))
+	mw.Close()
+	return buf, mw.FormDataContentType()
+}
+
+// upload posts content as a form to path and returns the id and secret from
+// the urls sent back
+func (s *testServer) upload(t *testing.T, path, content string, fields ...string) (string, string) {
+	t.Helper()
+	body, contentType := form(content, fields...)
+	resp, out := s.do(t, "POST", path, body, map[string]string{"Content-Type": contentType})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload got %d: %s", resp.StatusCode, out)
+	}
+	return parseURLs(t, out)
+}
+
+// parseURLs returns the id and secret in the text urlPage
+func parseURLs(t *testing.T, out string) (string, string) {
+	t.Helper()
+	urls := strings.Fields(out)
+	if len(urls) < 2 {
+		t.Fatalf("expected urls, got %q", out)
+	}
+	return urls[0][strings.LastIndex(urls[0], "/")+1:], urls[1][strings.LastIndex(urls[1], "/")+1:]
+}
+
+func TestUploadAndGet(t *testing.T) {
+	srv := newTestServer(t, nil)
+	id, _ := srv.upload(t, "/", "hello gobin")
+	if code, body := srv.get(t, "/"+id); code != http.StatusOK || body != "hello gobin" {
+		t.Fatalf("got %d %q", code, body)
+	}
+	if code, _ := srv.get(t, "/nope"); code != http.StatusNotFound {
+		t.Fatalf("got %d for an unknown id", code)
+	}
+}
+
+func TestRawUpload(t *testing.T) {
+	srv := newTestServer(t, nil)
+	resp, out := srv.do(t, "PUT", "/log.txt", strings.NewReader("raw put body"), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d: %s", resp.StatusCode, out)
+	}
+	id, _ := parseURLs(t, out)
+	if code, body := srv.get(t, "/"+id); code != http.StatusOK || body != "raw put body" {
+		t.Fatalf("got %d %q", code, body)
+	}
+	meta, err := srv.db.GetMetadataByID(id)
+	if err != nil {
+		t.Fatal(err)
+	} else if meta.Filename.String != "log.txt" {
+		t.Fatalf("got filename %q", meta.Filename.String)
+	}
+}
+
+func TestUploadTooLarge(t *testing.T) {
+	srv := newTestServer(t, &Limits{MaxGobSize: 100})
+	body, contentType := form(strings.Repeat("x", 200))
+	resp, out := srv.do(t, "POST", "/", body, map[string]string{"Content-Type": contentType})
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got %d: %s", resp.StatusCode, out)
+	}
+	resp, out = srv.do(t, "POST", "/", strings.NewReader(strings.Repeat("x", 200)), nil)
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("raw got %d: %s", resp.StatusCode, out)
+	}
+}
+
+func TestMultipartBodyTooLarge(t *testing.T) {
+	srv := newTestServer(t, &Limits{MaxGobSize: 100})
+	// Each field is allowed, but all of them are more than the form's slack
+	var fields []string
+	for i := 0; i < 400; i++ {
+		fields = append(fields, "x", strings.Repeat("y", 4<<10))
+	}
+	body, contentType := form("hello gobin", fields...)
+	resp, out := srv.do(t, "POST", "/", body, map[string]string{"Content-Type": contentType})
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got %d: %s", resp.StatusCode, out)
+	}
+}
+
+func TestExpireHandler(t *testing.T) {
+	srv := newTestServer(t, nil)
+	id, secret := srv.upload(t, "/", "hello gobin")
+	if code, body := srv.get(t, "/expire/"+secret); code != http.StatusOK {
+		t.Fatalf("got %d: %s", code, body)
+	}
+	if code, _ := srv.get(t, "/"+id); code != http.StatusGone {
+		t.Fatalf("got %d after expiring", code)
+	}
+	if code, _ := srv.get(t, "/expire/nope"); code != http.StatusNotFound {
+		t.Fatalf("got %d for an unknown secret", code)
+	}
+}
+
+func TestDeleteHandler(t *testing.T) {
+	srv := newTestServer(t, nil)
+	id, secret := srv.upload(t, "/", "hello gobin")
+	if resp, _ := srv.do(t, "DELETE", "/"+id, nil, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got %d without a secret", resp.StatusCode)
+	}
+	if resp, _ := srv.do(t, "DELETE", "/"+id+"?secret=nope", nil, nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("got %d with the wrong secret", resp.StatusCode)
+	}
+	resp, out := srv.do(t, "DELETE", "/"+id, nil, map[string]string{"X-Gob-Secret": secret})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d: %s", resp.StatusCode, out)
+	}
+	if code, _ := srv.get(t, "/"+id); code != http.StatusGone {
+		t.Fatalf("got %d after deleting", code)
+	}
+	// Already deleted isn't the same as never existed
+	resp, out = srv.do(t, "DELETE", "/"+id, nil, map[string]string{"X-Gob-Secret": secret})
+	if resp.StatusCode != http.StatusGone {
+		t.Fatalf("got %d deleting twice: %s", resp.StatusCode, out)
+	}
+	if resp, _ := srv.do(t, "DELETE", "/nope", nil, map[string]string{"X-Gob-Secret": secret}); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("got %d deleting an unknown id", resp.StatusCode)
+	}
+
+	// Browsers confirm with a form before the POST deletes it
+	id, secret = srv.upload(t, "/", "hello gobin")
+	resp, out = srv.do(t, "GET", "/delete/"+secret, nil, map[string]string{"User-Agent": "Mozilla/5.0"})
+	if resp.StatusCode != http.StatusOK || !strings.Contains(out, "<form") {
+		t.Fatalf("got %d: %s", resp.StatusCode, out)
+	}
+	if code, _ := srv.get(t, "/"+id); code != http.StatusOK {
+		t.Fatalf("got %d, the confirm page deleted it", code)
+	}
+	if resp, out := srv.do(t, "POST", "/delete/"+secret, nil, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d: %s", resp.StatusCode, out)
+	}
+	if code, _ := srv.get(t, "/"+id); code != http.StatusGone {
+		t.Fatalf("got %d after deleting", code)
+	}
+	if resp, _ := srv.do(t, "POST", "/delete/"+secret, nil, nil); resp.StatusCode != http.StatusGone {
+		t.Fatalf("got %d deleting twice", resp.StatusCode)
+	}
+}
+
+func TestHead(t *testing.T) {
+	srv := newTestServer(t, nil)
+	content := "hello gobin"
+	id, _ := srv.upload(t, "/", content)
+	for _, path := range []string{"/" + id, "/" + id + ".go", "/api/v1/gobs/" + id + "/content"} {
+		resp, body := srv.do(t, "HEAD", path, nil, map[string]string{"Accept-Encoding": "identity"})
+		if resp.StatusCode != http.StatusOK || body != "" {
+			t.Fatalf("%s: got %d %q", path, resp.StatusCode, body)
+		}
+	}
+	resp, _ := srv.do(t, "HEAD", "/"+id, nil, map[string]string{"Accept-Encoding": "identity"})
+	if resp.ContentLength != int64(len(content)) || resp.Header.Get("ETag") == "" {
+		t.Fatalf("got Content-Length %d and ETag %q", resp.ContentLength, resp.Header.Get("ETag"))
+	}
+
+	// A HEAD doesn't use up a view, even of a rendered page
+	id, _ = srv.upload(t, "/", content, "burn", "1")
+	for _, path := range []string{"/" + id, "/" + id + ".go?view"} {
+		if resp, _ := srv.do(t, "HEAD", path, nil, map[string]string{"Accept": "text/html"}); resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: got %d", path, resp.StatusCode)
+		}
+	}
+	if code, body := srv.get(t, "/"+id); code != http.StatusOK || body != content {
+		t.Fatalf("got %d %q after a HEAD", code, body)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	srv := newTestServer(t, nil)
+	content := "# Intro\n\n" +
+		"<script>alert('script')</script>\n\n" +
+		"[x](javascript:alert('link'))\n\n" +
+		"<img src=\"x.png\" onerror=\"alert('img')\">\n\n" +
+		"## Code Block\n\n" +
+		"```go\nfunc main() {}\n```\n"
+	id, _ := srv.upload(t, "/", content)
+	code, body := srv.get(t, "/"+id+".md")
+	if code != http.StatusOK {
+		t.Fatalf("got %d %q", code, body)
+	}
+	for _, bad := range []string{"<script>", "javascript:", "onerror", "alert("} {
+		if strings.Contains(body, bad) {
+			t.Errorf("rendered markdown has %q", bad)
+		}
+	}
+	for _, want := range []string{
+		`<pre class="chroma">`,
+		`<span class="kd">func</span>`,
+		`<h1 id="intro">`,
+		`<a href="#intro">Intro</a>`,
+		`<h2 id="code-block">`,
+		`<a href="#code-block">Code Block</a>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("rendered markdown doesn't have %q", want)
+		}
+	}
+}
+
+func TestBurnAfterReading(t *testing.T) {
+	srv := newTestServer(t, nil)
+	id, _ := srv.upload(t, "/", "hello gobin", "burn", "1")
+	if code, body := srv.get(t, "/"+id); code != http.StatusOK || body != "hello gobin" {
+		t.Fatalf("got %d %q", code, body)
+	}
+	if code, _ := srv.get(t, "/"+id); code == http.StatusOK {
+		t.Fatal("second view of a burned gob succeeded")
+	}
+
+	// Only one of many concurrent views gets it
+	id, _ = srv.upload(t, "/", "hello gobin", "burn", "1")
+	codes := make(chan int, 20)
+	for i := 0; i < cap(codes); i++ {
+		go func() {
+			resp, err := http.Get(srv.URL + "/" + id)
+			if err != nil {
+				codes <- 0
+				return
+			}
+			resp.Body.Close()
+			codes <- resp.StatusCode
+		}()
+	}
+	ok := 0
+	for i := 0; i < cap(codes); i++ {
+		if <-codes == http.StatusOK {
+			ok++
+		}
+	}
+	if ok != 1 {
+		t.Fatalf("%d concurrent views succeeded", ok)
+	}
+}
+
+func TestEncryptedGob(t *testing.T) {
+	srv := newTestServer(t, nil)
+	id, _ := srv.upload(t, "/", "hello gobin", "encrypt", "k1")
+	for _, c := range []struct {
+		query string
+		code  int
+	}{
+		{"", http.StatusBadRequest},
+		{"?encrypt=k2", http.StatusForbidden},
+		{"?encrypt=k1", http.StatusOK},
+	} {
+		if code, body := srv.get(t, "/"+id+c.query); code != c.code {
+			t.Fatalf("%q got %d: %s", c.query, code, body)
+		}
+	}
+}
+
+func TestAPI(t *testing.T) {
+	srv := newTestServer(t, nil)
+	body, contentType := form("hello gobin", "ttl", "1h")
+	resp, out := srv.do(t, "POST", "/api/v1/gobs", body, map[string]string{"Content-Type": contentType})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("got %d: %s", resp.StatusCode, out)
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		t.Fatal("no Location")
+	}
+	id := location[strings.LastIndex(location, "/")+1:]
+	if code, body := srv.get(t, "/api/v1/gobs/"+id); code != http.StatusOK || !strings.Contains(body, `"id":"`+id+`"`) {
+		t.Fatalf("got %d: %s", code, body)
+	}
+	if code, body := srv.get(t, "/api/v1/gobs/"+id+"/content"); code != http.StatusOK || body != "hello gobin" {
+		t.Fatalf("got %d %q", code, body)
+	}
+	resp, out = srv.do(t, "GET", "/nope", nil, map[string]string{"Accept": "application/json"})
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(out, `"error"`) {
+		t.Fatalf("got %d: %s", resp.StatusCode, out)
+	}
+}
+
+func TestViewLimitedInBrowser(t *testing.T) {
+	srv := newTestServer(t, nil)
+	id, _ := srv.upload(t, "/", "hello gobin", "views", "2")
+	browser := map[string]string{"User-Agent": "Mozilla/5.0"}
+	// Previews and the confirm page don't take a view
+	for i := 0; i < 3; i++ {
+		resp, out := srv.do(t, "GET", "/"+id+".go", nil, browser)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(out, `name="view"`) {
+			t.Fatalf("got %d: %s", resp.StatusCode, out)
+		}
+	}
+	meta, err := srv.db.GetMetadataByID(id)
+	if err != nil {
+		t.Fatal(err)
+	} else if meta.ViewsLeft.Int64 != 2 {
+		t.Fatalf("got %d views left", meta.ViewsLeft.Int64)
+	}
+	resp, out := srv.do(t, "GET", "/"+id+".go?view=1", nil, browser)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(out, "hello") || strings.Contains(out, `name="view"`) {
+		t.Fatalf("got %d: %s", resp.StatusCode, out)
+	}
+	if meta, err = srv.db.GetMetadataByID(id); err != nil {
+		t.Fatal(err)
+	} else if meta.ViewsLeft.Int64 != 1 {
+		t.Fatalf("got %d views left", meta.ViewsLeft.Int64)
+	}
+	// Not browsers get it straight away
+	if code, body := srv.get(t, "/"+id); code != http.StatusOK || body != "hello gobin" {
+		t.Fatalf("got %d %q", code, body)
+	}
+}
+
+func TestRangeAndETag(t *testing.T) {
+	srv := newTestServer(t, nil)
+	content := "0123456789abcdefghij"
+	id, _ := srv.upload(t, "/", content)
+	meta, err := srv.db.GetMetadataByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := `"` + meta.ContentHash.String + `"`
+
+	resp, body := srv.do(t, "GET", "/"+id, nil, map[string]string{"Accept-Encoding": "identity"})
+	if resp.StatusCode != http.StatusOK || body != content {
+		t.Fatalf("got %d %q", resp.StatusCode, body)
+	} else if resp.Header.Get("ETag") != etag {
+		t.Fatalf("got ETag %q, want %q", resp.Header.Get("ETag"), etag)
+	} else if resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("got Accept-Ranges %q", resp.Header.Get("Accept-Ranges"))
+	}
+	// Compressed bytes are different, so the ETag is weak
+	resp, _ = srv.do(t, "GET", "/"+id, nil, map[string]string{"Accept-Encoding": "zstd"})
+	if resp.Header.Get("Content-Encoding") != "zstd" || resp.Header.Get("ETag") != "W/"+etag {
+		t.Fatalf("got %q with ETag %q", resp.Header.Get("Content-Encoding"), resp.Header.Get("ETag"))
+	}
+
+	for rng, want := range map[string]string{
+		"bytes=5-9": "56789",
+		"bytes=15-": "fghij",
+		"bytes=-3":  "hij",
+	} {
+		resp, body := srv.do(t, "GET", "/"+id, nil, map[string]string{"Range": rng})
+		if resp.StatusCode != http.StatusPartialContent || body != want {
+			t.Fatalf("%s got %d %q, want %q", rng, resp.StatusCode, body, want)
+		}
+	}
+	if resp, _ := srv.do(t, "GET", "/"+id, nil, map[string]string{"Range": "bytes=50-"}); resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("got %d for a range past the end", resp.StatusCode)
+	}
+	// A stale If-Range gets the whole gob
+	resp, body = srv.do(t, "GET", "/"+id, nil, map[string]string{"Range": "bytes=0-1", "If-Range": `"stale"`})
+	if resp.StatusCode != http.StatusOK || body != content {
+		t.Fatalf("got %d %q for a stale If-Range", resp.StatusCode, body)
+	}
+
+	resp, body = srv.do(t, "GET", "/"+id, nil, map[string]string{"If-None-Match": etag})
+	if resp.StatusCode != http.StatusNotModified || body != "" {
+		t.Fatalf("got %d %q for a matching If-None-Match", resp.StatusCode, body)
+	}
+	if resp, _ := srv.do(t, "GET", "/"+id, nil, map[string]string{"If-None-Match": `"other"`}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d for another If-None-Match", resp.StatusCode)
+	}
+
+	// Encrypted gobs have no ETag, and views are only taken whole
+	id, _ = srv.upload(t, "/", content, "encrypt", "k1")
+	if resp, _ := srv.do(t, "GET", "/"+id+"?encrypt=k1", nil, nil); resp.Header.Get("ETag") != "" {
+		t.Fatalf("got ETag %q for an encrypted gob", resp.Header.Get("ETag"))
+	}
+	id, _ = srv.upload(t, "/", content, "views", "2")
+	resp, body = srv.do(t, "GET", "/"+id, nil, map[string]string{"Range": "bytes=0-1"})
+	if resp.StatusCode != http.StatusOK || body != content {
+		t.Fatalf("got %d %q for a range of a gob with limited views", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,60 @@
+package gobin
+
+import (
+	"bytes"
+	htmlTemplate "html/template"
+
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/levenlabs/errctx"
+)
+
+// maxRenderSize is the largest gob that's highlighted or rendered as
+// markdown, since it's done in memory. Larger ones are served raw.
+const maxRenderSize = 1 << 20
+
+// highlightFormatter writes classes for static/css/chroma.css, with line
+// numbers linked as #L<n>
+var highlightFormatter = html.New(
+	html.WithClasses(true),
+	html.WithLineNumbers(true),
+	html.LineNumbersInTable(true),
+	html.LinkableLineNumbers(true, "L"),
+	html.TabWidth(4),
+)
+
+// getLexer returns the lexer for lang, which is a name, alias or extension,
+// or if it's empty for meta's filename or the content of data
+func getLexer(lang string, meta *db.Metadata, data []byte) chroma.Lexer {
+	var lexer chroma.Lexer
+	switch {
+	case lang != "":
+		lexer = lexers.Get(lang)
+	case meta.Filename.Valid:
+		lexer = lexers.Match(meta.Filename.String)
+	}
+	if lexer == nil && lang == "" {
+		lexer = lexers.Analyse(string(data))
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	return chroma.Coalesce(lexer)
+}
+
+// highlight returns data as highlighted HTML and the name of its language
+func highlight(lang string, meta *db.Metadata, data []byte) (htmlTemplate.HTML, string, error) {
+	lexer := getLexer(lang, meta, data)
+	iterator, err := lexer.Tokenise(nil, string(data))
+	if err != nil {
+		return "", "", errctx.Mark(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := highlightFormatter.Format(buf, styles.Monokai, iterator); err != nil {
+		return "", "", errctx.Mark(err)
+	}
+	return htmlTemplate.HTML(buf.String()), lexer.Config().Name, nil
+}
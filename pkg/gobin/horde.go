@@ -0,0 +1,168 @@
+package gobin
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/kinghrothgar/gobin/pkg/gob"
+	"github.com/kinghrothgar/gobin/pkg/store"
+	"github.com/levenlabs/go-llog"
+)
+
+// maxHordeNameLen is the longest a horde name can be
+const maxHordeNameLen = 64
+
+var hordeNameReg = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+
+// getHordeExpireDate returns when a horde created by an upload should expire,
+// from the "horde_ttl" upload parameter. The zero Time is never. Errors are
+// meant for the user.
+func getHordeExpireDate(params url.Values, limits *Limits) (time.Time, error) {
+	ttlStr := params.Get("horde_ttl")
+	if ttlStr == "" {
+		return time.Time{}, nil
+	}
+	ttl, err := parseTTL(ttlStr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid horde_ttl %q", ttlStr)
+	}
+	if ttl <= 0 {
+		return time.Time{}, fmt.Errorf("horde_ttl must be positive")
+	}
+	if limits.MaxTTL > 0 && ttl > limits.MaxTTL {
+		return time.Time{}, fmt.Errorf("hordes can live at most %s", limits.MaxTTL)
+	}
+	return time.Now().Add(ttl), nil
+}
+
+// getHordeOptions returns the name of the horde in the url or the "horde"
+// upload parameter, "" if there isn't one, and when the horde should expire if
+// the upload creates it. Errors are meant for the user.
+func getHordeOptions(r *http.Request, params url.Values, limits *Limits) (string, time.Time, error) {
+	name := mux.Vars(r)["horde"]
+	if name == "" {
+		name = params.Get("horde")
+	}
+	if name == "" {
+		return "", time.Time{}, nil
+	}
+	if !hordeNameReg.MatchString(name) || len(name) > maxHordeNameLen {
+		msg := fmt.Sprintf("horde name must be at most %d letters, numbers, _ or -", maxHordeNameLen)
+		return "", time.Time{}, badRequest(msg)
+	}
+	expireDate, err := getHordeExpireDate(params, limits)
+	if err != nil {
+		return "", time.Time{}, badRequest(err.Error())
+	}
+	return name, expireDate, nil
+}
+
+// checkHorde fails if the gob of an upload with params couldn't be put in its
+// horde, so that's found before the gob is uploaded
+func checkHorde(r *http.Request, g *gob.Gob, params url.Values, limits *Limits) error {
+	name, _, err := getHordeOptions(r, params, limits)
+	if err != nil || name == "" {
+		return err
+	}
+	return g.CheckHorde(name)
+}
+
+// addToHorde puts the uploaded gob of meta in the horde in the url or the
+// "horde" upload parameter, if there is one. It returns the horde's name and,
+// if the upload created it, its secret.
+func addToHorde(r *http.Request, g *gob.Gob, meta *db.Metadata, params url.Values, limits *Limits) (string, string, error) {
+	name, expireDate, err := getHordeOptions(r, params, limits)
+	if err != nil || name == "" {
+		return "", "", err
+	}
+	horde, created, err := g.AddToHorde(meta, name, expireDate)
+	if err != nil {
+		return "", "", err
+	}
+	if !created {
+		return horde.Name, "", nil
+	}
+	return horde.Name, horde.Secret, nil
+}
+
+// GetHordeHandler lists the gobs in the horde in the url
+func GetHordeHandler(db db.DB, backend store.Backend, tmpls *Templates) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g := gob.NewGob(r.Context(), db, backend)
+		horde, metas, err := g.GetHorde(mux.Vars(r)["horde"])
+		if err != nil {
+			returnError(w, r, tmpls, err)
+			return
+		}
+		pageBytes, err := tmpls.GetHordePage(getScheme(r), getPageType(r), horde, metas)
+		if err != nil {
+			returnError(w, r, tmpls, err)
+			return
+		}
+		w.Write(pageBytes)
+	})
+}
+
+// archiveContentTypes are the Content-Type of each gob.Archive format
+var archiveContentTypes = map[string]string{
+	gob.ArchiveTar: "application/x-tar",
+	gob.ArchiveZip: "application/zip",
+}
+
+// GetHordeArchiveHandler downloads the horde in the url as a tar or zip
+// archive, by the "format" in the url
+func GetHordeArchiveHandler(db db.DB, backend store.Backend, tmpls *Templates) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		format := vars["format"]
+		contentType, ok := archiveContentTypes[format]
+		if !ok {
+			returnError(w, r, tmpls, badRequest("archive format must be tar or zip"))
+			return
+		}
+		g := gob.NewGob(r.Context(), db, backend)
+		horde, metas, err := g.GetHorde(vars["horde"])
+		if err != nil {
+			returnError(w, r, tmpls, err)
+			return
+		}
+		h := w.Header()
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", horde.Name+"."+format))
+		ww := &writtenWriter{ResponseWriter: w}
+		if err := g.DownloadHorde(ww, metas, format); err != nil {
+			if ww.written {
+				llog.Error("failed to download horde", llog.KV{"horde": horde.Name}, llog.ErrKV(err))
+				return
+			}
+			returnError(w, r, tmpls, err)
+			return
+		}
+		llog.Debug("downloaded horde", llog.KV{"horde": horde.Name, "format": format})
+	})
+}
+
+// GetHordeExpireHandler expires the horde with the secret in the url, and
+// every gob in it
+func GetHordeExpireHandler(db db.DB, backend store.Backend, tmpls *Templates) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g := gob.NewGob(r.Context(), db, backend)
+		horde, err := g.ExpireHorde(mux.Vars(r)["secret"])
+		if err != nil {
+			returnError(w, r, tmpls, err)
+			return
+		}
+		pageBytes, err := tmpls.GetMessPage(getPageType(r), "expired horde "+horde.Name)
+		if err != nil {
+			returnError(w, r, tmpls, err)
+			return
+		}
+		w.Write(pageBytes)
+		llog.Debug("expired horde", llog.KV{"horde": horde.Name})
+	})
+}
@@ -0,0 +1,174 @@
+package gobin
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hordeSecret returns the secret of the horde in the urls of an upload, ""
+// if the upload didn't create it
+func hordeSecret(out string) string {
+	for _, u := range strings.Fields(out) {
+		if i := strings.Index(u, "/horde/expire/"); i >= 0 {
+			return u[i+len("/horde/expire/"):]
+		}
+	}
+	return ""
+}
+
+// assertNothingUploaded fails if a gob was uploaded and then deleted, which
+// is what a horde found to be bad after the upload used to do
+func assertNothingUploaded(t *testing.T, srv *testServer) {
+	t.Helper()
+	if n, err := srv.db.DeleteTombstones(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("%d gobs were uploaded before the horde was checked", n)
+	}
+}
+
+func TestHordeUpload(t *testing.T) {
+	srv := newTestServer(t, nil)
+	body, contentType := form("first")
+	resp, out := srv.do(t, "POST", "/logs", body, map[string]string{"Content-Type": contentType})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d: %s", resp.StatusCode, out)
+	}
+	first, _ := parseURLs(t, out)
+	if hordeSecret(out) == "" {
+		t.Fatalf("creating the horde gave no secret: %s", out)
+	}
+	resp, out = srv.do(t, "PUT", "/?horde=logs", strings.NewReader("second"), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d: %s", resp.StatusCode, out)
+	}
+	second, _ := parseURLs(t, out)
+	if hordeSecret(out) != "" {
+		t.Fatalf("joining the horde gave its secret: %s", out)
+	}
+	code, out := srv.get(t, "/horde/logs")
+	if code != http.StatusOK || !strings.Contains(out, "/"+first) || !strings.Contains(out, "/"+second) {
+		t.Fatalf("got %d: %s", code, out)
+	}
+	if code, _ := srv.get(t, "/horde/nope"); code != http.StatusNotFound {
+		t.Fatalf("got %d for an unknown horde", code)
+	}
+}
+
+func TestHordeCheckedBeforeUpload(t *testing.T) {
+	srv := newTestServer(t, &Limits{MaxTTL: time.Hour})
+	for _, path := range []string{
+		"/?horde=bad.name",
+		"/?horde=" + strings.Repeat("x", maxHordeNameLen+1),
+		"/?horde=logs&horde_ttl=nope",
+		"/?horde=logs&horde_ttl=2h",
+	} {
+		resp, out := srv.do(t, "PUT", path, strings.NewReader("hello gobin"), nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s got %d: %s", path, resp.StatusCode, out)
+		}
+	}
+	body, contentType := form("hello gobin", "horde", "bad.name")
+	resp, out := srv.do(t, "POST", "/", body, map[string]string{"Content-Type": contentType})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("form got %d: %s", resp.StatusCode, out)
+	}
+	assertNothingUploaded(t, srv)
+
+	resp, out = srv.do(t, "PUT", "/?horde=logs", strings.NewReader("hello gobin"), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d: %s", resp.StatusCode, out)
+	}
+	if code, out := srv.get(t, "/horde/expire/"+hordeSecret(out)); code != http.StatusOK || !strings.Contains(out, "expired horde logs") {
+		t.Fatalf("got %d: %s", code, out)
+	}
+	resp, out = srv.do(t, "PUT", "/?horde=logs", strings.NewReader("hello gobin"), nil)
+	if resp.StatusCode != http.StatusGone {
+		t.Fatalf("got %d uploading to an expired horde: %s", resp.StatusCode, out)
+	}
+	body, contentType = form("hello gobin")
+	resp, out = srv.do(t, "POST", "/logs", body, map[string]string{"Content-Type": contentType})
+	if resp.StatusCode != http.StatusGone {
+		t.Fatalf("form got %d uploading to an expired horde: %s", resp.StatusCode, out)
+	}
+	assertNothingUploaded(t, srv)
+}
+
+func TestHordeArchive(t *testing.T) {
+	srv := newTestServer(t, nil)
+	want := map[string]string{}
+	for _, content := range []string{"one", "two"} {
+		resp, out := srv.do(t, "PUT", "/"+content+".txt?horde=logs", strings.NewReader(content), nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %d: %s", resp.StatusCode, out)
+		}
+		id, _ := parseURLs(t, out)
+		want[id+"-"+content+".txt"] = content
+	}
+	// Left out of archives
+	srv.upload(t, "/logs", "encrypted", "encrypt", "k1")
+	srv.upload(t, "/logs", "burned", "burn", "1")
+
+	resp, out := srv.do(t, "GET", "/horde/logs.tar", nil, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-tar" {
+		t.Fatalf("got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	got := map[string]string{}
+	tr := tar.NewReader(strings.NewReader(out))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(tr)
+		got[hdr.Name] = string(content)
+	}
+	assertArchive(t, "tar", got, want)
+
+	resp, out = srv.do(t, "GET", "/horde/logs.zip", nil, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader([]byte(out)), int64(len(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = map[string]string{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(r)
+		r.Close()
+		got[f.Name] = string(content)
+	}
+	assertArchive(t, "zip", got, want)
+}
+
+func assertArchive(t *testing.T, format string, got, want map[string]string) {
+	t.Helper()
+	var names []string
+	for name := range got {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(got) != len(want) {
+		t.Fatalf("%s has %v, want %d files", format, names, len(want))
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Fatalf("%s has %q as %q, want %q", format, name, got[name], content)
+		}
+	}
+}
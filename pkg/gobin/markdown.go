@@ -0,0 +1,129 @@
+package gobin
+
+import (
+	"bytes"
+	htmlTemplate "html/template"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/kinghrothgar/gobin/pkg/db"
+	"github.com/levenlabs/errctx"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+	goldmark.WithRendererOptions(
+		renderer.WithNodeRenderers(util.Prioritized(&codeBlockRenderer{}, 100)),
+	),
+)
+
+// markdownPolicy is what rendered markdown can have. Raw HTML is already
+// left out by goldmark, this is in case anything else gets through.
+var markdownPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// For chroma and the table of contents
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^[a-z0-9 -]+$`)).OnElements("div", "pre", "code", "span")
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	return p
+}()
+
+// codeBlockFormatter writes classes for static/css/chroma.css
+var codeBlockFormatter = html.New(html.WithClasses(true), html.TabWidth(4))
+
+// codeBlockRenderer highlights fenced code blocks in their info string's
+// language
+type codeBlockRenderer struct{}
+
+func (r *codeBlockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.render)
+}
+
+func (r *codeBlockRenderer) render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.FencedCodeBlock)
+	code := &bytes.Buffer{}
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code.Write(line.Value(source))
+	}
+	lexer := lexers.Get(string(n.Language(source)))
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code.String())
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkSkipChildren, codeBlockFormatter.Format(w, styles.Monokai, iterator)
+}
+
+// isMarkdown is whether the gob of meta should be rendered as markdown, which
+// it is with ?md or as /{id}.md. Gobs with a markdown filename are rendered
+// for browsers, unless raw is given.
+func isMarkdown(r *http.Request, meta *db.Metadata) bool {
+	if meta.Size > maxRenderSize {
+		return false
+	}
+	params := r.URL.Query()
+	if _, md := params["md"]; md {
+		return true
+	}
+	if lang := getLang(r); lang != "" {
+		return lang == "md" || lang == "markdown"
+	}
+	if _, raw := params["raw"]; raw || getPageType(r) != "HTML" || !meta.Filename.Valid {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(meta.Filename.String))
+	return ext == ".md" || ext == ".markdown"
+}
+
+// renderMarkdown returns source rendered as sanitized HTML, and its table of
+// contents if it has more than one heading
+func renderMarkdown(source []byte) (htmlTemplate.HTML, []*TOCEntry, error) {
+	doc := markdown.Parser().Parse(text.NewReader(source))
+	var toc []*TOCEntry
+	err := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+		id, _ := heading.AttributeString("id")
+		idBytes, _ := id.([]byte)
+		toc = append(toc, &TOCEntry{
+			Level: heading.Level,
+			ID:    string(idBytes),
+			Text:  string(heading.Text(source)),
+		})
+		return ast.WalkSkipChildren, nil
+	})
+	if err != nil {
+		return "", nil, errctx.Mark(err)
+	}
+	if len(toc) < 2 {
+		toc = nil
+	}
+	buf := &bytes.Buffer{}
+	if err := markdown.Renderer().Render(buf, source, doc); err != nil {
+		return "", nil, errctx.Mark(err)
+	}
+	return htmlTemplate.HTML(markdownPolicy.SanitizeBytes(buf.Bytes())), toc, nil
+}
@@ -0,0 +1,152 @@
+package gobin
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/levenlabs/errctx"
+)
+
+// errQuotaExceeded is returned once a client has uploaded its daily quota
+var errQuotaExceeded = newHTTPError(http.StatusTooManyRequests, "daily upload quota exceeded, try again tomorrow")
+
+// Quota limits how many bytes each client can upload a day. Days are in UTC
+// and counts are kept in memory, so each server has its own.
+type Quota struct {
+	limit int64
+	// trustedProxies are the only ones whose X-Real-IP is believed
+	trustedProxies []*net.IPNet
+
+	mu   sync.Mutex
+	day  time.Time
+	used map[string]int64
+}
+
+// NewQuota returns a Quota of limit bytes a day. Clients are told apart by
+// the X-Real-IP header of requests from trustedProxies, and by the remote ip
+// of the rest.
+func NewQuota(limit int64, trustedProxies []*net.IPNet) *Quota {
+	return &Quota{limit: limit, trustedProxies: trustedProxies, used: map[string]int64{}}
+}
+
+// ParseTrustedProxies parses a comma separated list of ips and CIDRs
+func ParseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, errctx.Mark(fmt.Errorf("invalid trusted proxy %q", p))
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, errctx.Mark(fmt.Errorf("invalid trusted proxy %q", p))
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// resetIfNewDay forgets yesterday's counts, q.mu must be held
+func (q *Quota) resetIfNewDay() {
+	if today := time.Now().UTC().Truncate(24 * time.Hour); !today.Equal(q.day) {
+		q.day = today
+		q.used = map[string]int64{}
+	}
+}
+
+// Remaining returns how many bytes client can still upload today
+func (q *Quota) Remaining(client string) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.resetIfNewDay()
+	return q.limit - q.used[client]
+}
+
+// take counts n bytes against client's quota, and is whether it had room
+func (q *Quota) take(client string, n int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.resetIfNewDay()
+	q.used[client] += n
+	return q.used[client] <= q.limit
+}
+
+// giveBack uncounts n bytes of client's, e.g. of a failed upload
+func (q *Quota) giveBack(client string, n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.used[client] -= n; q.used[client] <= 0 {
+		delete(q.used, client)
+	}
+}
+
+// quotaReader counts what's read against client's quota, and returns
+// errQuotaExceeded once it's used up
+type quotaReader struct {
+	r      io.Reader
+	quota  *Quota
+	client string
+	n      int64
+}
+
+func (qr *quotaReader) Read(p []byte) (int, error) {
+	n, err := qr.r.Read(p)
+	qr.n += int64(n)
+	if !qr.quota.take(qr.client, int64(n)) {
+		return n, errQuotaExceeded
+	}
+	return n, err
+}
+
+// giveBack uncounts everything read, for when the upload failed. qr can be
+// nil, when there's no quota.
+func (qr *quotaReader) giveBack() {
+	if qr == nil {
+		return
+	}
+	qr.quota.giveBack(qr.client, qr.n)
+	qr.n = 0
+}
+
+// client returns who made r. X-Real-IP is only used from a trusted proxy,
+// anyone else could set it to get a fresh quota.
+func (q *Quota) client(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := r.Header.Get("X-Real-IP"); ip != "" && q.trusted(host) {
+		return ip
+	}
+	return host
+}
+
+// trusted is whether host is one of the trusted proxies
+func (q *Quota) trusted(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range q.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package gobin
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.1, 127.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t, &Limits{Quota: NewQuota(20, trusted)})
+	put := func(content, realIP string) int {
+		hdr := map[string]string{}
+		if realIP != "" {
+			hdr["X-Real-IP"] = realIP
+		}
+		resp, _ := srv.do(t, "PUT", "/", strings.NewReader(content), hdr)
+		return resp.StatusCode
+	}
+	if code := put(strings.Repeat("x", 15), "1.1.1.1"); code != http.StatusOK {
+		t.Fatalf("got %d", code)
+	}
+	if code := put(strings.Repeat("x", 10), "1.1.1.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("got %d, want 429 over the quota", code)
+	}
+	// The failed upload didn't count, so there's still room for 5
+	if code := put(strings.Repeat("x", 5), "1.1.1.1"); code != http.StatusOK {
+		t.Fatalf("got %d", code)
+	}
+	// The test server is on 127.0.0.1, which is trusted to say who's behind it
+	if code := put(strings.Repeat("x", 15), "2.2.2.2"); code != http.StatusOK {
+		t.Fatalf("got %d for another client", code)
+	}
+}
+
+func TestQuotaUntrustedProxy(t *testing.T) {
+	srv := newTestServer(t, &Limits{Quota: NewQuota(20, nil)})
+	put := func(content, realIP string) int {
+		resp, _ := srv.do(t, "PUT", "/", strings.NewReader(content), map[string]string{"X-Real-IP": realIP})
+		return resp.StatusCode
+	}
+	if code := put(strings.Repeat("x", 15), "1.1.1.1"); code != http.StatusOK {
+		t.Fatalf("got %d", code)
+	}
+	// Making up another X-Real-IP doesn't get a fresh quota
+	if code := put(strings.Repeat("x", 15), "2.2.2.2"); code != http.StatusTooManyRequests {
+		t.Fatalf("got %d, want 429 for a spoofed X-Real-IP", code)
+	}
+}
+
+func TestQuotaFailedForm(t *testing.T) {
+	quota := NewQuota(20, nil)
+	srv := newTestServer(t, &Limits{Quota: quota})
+	// Each fails on a field after the gob, once it's already uploaded
+	for _, field := range [][2]string{
+		{"ttl", "soon"},
+		{"encrypt", "key"},
+		{"horde", "not a name"},
+	} {
+		buf := &bytes.Buffer{}
+		mw := multipart.NewWriter(buf)
+		fw, _ := mw.CreateFormFile("g", "hello.txt")
+		fw.Write([]byte(strings.Repeat("x", 15)))
+		mw.WriteField(field[0], field[1])
+		mw.Close()
+		resp, _ := srv.do(t, "POST", "/", buf, map[string]string{"Content-Type": mw.FormDataContentType()})
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s: got %d, want 400", field[0], resp.StatusCode)
+		}
+		// The deleted gob doesn't count
+		if left := quota.Remaining("127.0.0.1"); left != 20 {
+			t.Fatalf("%s: got %d bytes of quota left, want 20", field[0], left)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := ParseTrustedProxies("192.168.1.1,10.0.0.0/8, ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewQuota(1, nets)
+	for host, want := range map[string]bool{
+		"192.168.1.1": true,
+		"192.168.1.2": false,
+		"10.9.8.7":    true,
+		"::1":         true,
+		"11.0.0.1":    false,
+		"nope":        false,
+	} {
+		if got := q.trusted(host); got != want {
+			t.Errorf("trusted(%q) = %v, want %v", host, got, want)
+		}
+	}
+	for _, s := range []string{"nope", "10.0.0.0/33"} {
+		if _, err := ParseTrustedProxies(s); err == nil {
+			t.Errorf("%q parsed", s)
+		}
+	}
+	if nets, err := ParseTrustedProxies(""); err != nil || len(nets) != 0 {
+		t.Fatalf("got %v %v for none", nets, err)
+	}
+}
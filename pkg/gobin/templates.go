@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"errors"
 	htmlTemplate "html/template"
+	"net/http"
+	"net/url"
 	textTemplate "text/template"
 
+	"github.com/kinghrothgar/gobin/pkg/db"
 	"github.com/levenlabs/errctx"
 )
 
@@ -27,6 +30,14 @@ type MessPage struct {
 	Message string
 }
 
+type ErrorPage struct {
+	Title      string
+	Tabs       *Tabs
+	Status     int
+	StatusText string
+	Message    string
+}
+
 type FormPage struct {
 	Domain string
 	Scheme string
@@ -35,12 +46,47 @@ type FormPage struct {
 }
 
 type URLPage struct {
-	Domain  string
-	Scheme  string
-	Title   string
-	ID      string
+	Domain string
+	Scheme string
+	Title  string
+	ID     string
 	Secret string
-	Tabs    *Tabs
+	// Lang is the language the gob was uploaded to be viewed as, if any
+	Lang string
+	// Horde is the horde the gob was uploaded into, if any, and HordeSecret
+	// its secret if the upload created it
+	Horde       string
+	HordeSecret string
+	Tabs        *Tabs
+}
+
+type HordePage struct {
+	Domain string
+	Scheme string
+	Title  string
+	Horde  *db.Horde
+	Gobs   []*db.Metadata
+	Tabs   *Tabs
+}
+
+type DeletePage struct {
+	Domain string
+	Scheme string
+	Title  string
+	ID     string
+	Secret string
+	Tabs   *Tabs
+}
+
+// ViewPage asks before a view of a gob with limited views is taken
+type ViewPage struct {
+	Title     string
+	ViewsLeft int64
+	// Path and Query are of the request for the gob, sent again with view
+	// set once the view is confirmed
+	Path  string
+	Query url.Values
+	Tabs  *Tabs
 }
 
 type GobPage struct {
@@ -50,9 +96,16 @@ type GobPage struct {
 }
 
 type MDPage struct {
-	Title    string
-	Language string
-	Data     htmlTemplate.HTML
+	Title string
+	TOC   []*TOCEntry
+	Data  htmlTemplate.HTML
+}
+
+// TOCEntry is a heading in the table of contents of an MDPage
+type TOCEntry struct {
+	Level int
+	ID    string
+	Text  string
 }
 
 type Templates struct {
@@ -94,19 +147,92 @@ func (t *Templates) GetMessPage(contentType string, message string) ([]byte, err
 	return t.execute(contentType, "messPage", page)
 }
 
-func (t *Templates) GetURLPage(scheme, contentType, id, secret string) ([]byte, error) {
+func (t *Templates) GetErrorPage(contentType string, status int, message string) ([]byte, error) {
+	tabs := &Tabs{}
+	page := &ErrorPage{
+		Title:      t.title,
+		Tabs:       tabs,
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Message:    message,
+	}
+	return t.execute(contentType, "errorPage", page)
+}
+
+// GetFormPage returns the upload form, which is only HTML
+func (t *Templates) GetFormPage(scheme string) ([]byte, error) {
+	tabs := &Tabs{Form: true}
+	page := &FormPage{Domain: t.domain, Scheme: scheme, Title: t.title, Tabs: tabs}
+	return t.execute("HTML", "formPage", page)
+}
+
+// GetLivePage is the link to follow a live upload, sent before it's done
+func (t *Templates) GetLivePage(scheme, contentType, id string) ([]byte, error) {
+	page := &URLPage{Domain: t.domain, Scheme: scheme, Title: t.title, ID: id, Tabs: &Tabs{}}
+	return t.execute(contentType, "livePage", page)
+}
+
+// GetURLPage is the page of links of an uploaded gob. horde is the horde it
+// was uploaded into, if any, and hordeSecret is only given when the upload
+// created the horde.
+func (t *Templates) GetURLPage(scheme, contentType, id, secret, lang, horde, hordeSecret string) ([]byte, error) {
 	tabs := &Tabs{Form: true}
 	page := &URLPage{
-		Domain:  t.domain,
-		Scheme:  scheme,
-		Title:   t.title,
-		ID:      id,
-		Secret: secret,
-		Tabs:    tabs,
+		Domain:      t.domain,
+		Scheme:      scheme,
+		Title:       t.title,
+		ID:          id,
+		Secret:      secret,
+		Lang:        lang,
+		Horde:       horde,
+		HordeSecret: hordeSecret,
+		Tabs:        tabs,
 	}
 	return t.execute(contentType, "urlPage", page)
 }
 
+// GetHordePage lists gobs, the ones in horde
+func (t *Templates) GetHordePage(scheme, contentType string, horde *db.Horde, gobs []*db.Metadata) ([]byte, error) {
+	tabs := &Tabs{Top: true}
+	page := &HordePage{
+		Domain: t.domain,
+		Scheme: scheme,
+		Title:  t.title,
+		Horde:  horde,
+		Gobs:   gobs,
+		Tabs:   tabs,
+	}
+	return t.execute(contentType, "hordePage", page)
+}
+
+func (t *Templates) GetDeletePage(scheme, contentType, id, secret string) ([]byte, error) {
+	tabs := &Tabs{}
+	page := &DeletePage{
+		Domain: t.domain,
+		Scheme: scheme,
+		Title:  t.title,
+		ID:     id,
+		Secret: secret,
+		Tabs:   tabs,
+	}
+	return t.execute(contentType, "deletePage", page)
+}
+
+func (t *Templates) GetViewPage(title string, viewsLeft int64, path string, query url.Values) ([]byte, error) {
+	page := &ViewPage{Title: title, ViewsLeft: viewsLeft, Path: path, Query: query, Tabs: &Tabs{}}
+	return t.execute("HTML", "viewPage", page)
+}
+
+func (t *Templates) GetGobPage(title, language string, data htmlTemplate.HTML) ([]byte, error) {
+	page := &GobPage{Title: title, Language: language, Data: data}
+	return t.execute("HTML", "gobPage", page)
+}
+
+func (t *Templates) GetMDPage(title string, toc []*TOCEntry, data htmlTemplate.HTML) ([]byte, error) {
+	page := &MDPage{Title: title, TOC: toc, Data: data}
+	return t.execute("HTML", "mdPage", page)
+}
+
 // BuildURLs builds the urls given the scheme (http/https), id and secret
 func (t *Templates) BuildURLs(scheme, id, secret string) string {
 	urls := scheme + "://" + t.domain + "/" + id + "\n"
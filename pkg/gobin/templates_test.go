@@ -0,0 +1,25 @@
+package gobin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestStaticLinksExist(t *testing.T) {
+	tmpl, err := ioutil.ReadFile("../../templates/htmlTemplates.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := regexp.MustCompile(`(?:href|src)="/static/([^"]+\.(?:css|js))"`).FindAllSubmatch(tmpl, -1)
+	if len(links) == 0 {
+		t.Fatal("no static links found")
+	}
+	for _, link := range links {
+		if _, err := os.Stat(filepath.Join("../../static", string(link[1]))); err != nil {
+			t.Errorf("templates link a missing file: %v", err)
+		}
+	}
+}
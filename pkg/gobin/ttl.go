@@ -0,0 +1,83 @@
+package gobin
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expireDateLayouts are what the expire parameter can be given as
+var expireDateLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"}
+
+// parseTTL is time.ParseDuration that also takes a number of days, e.g. "7d"
+func parseTTL(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.ParseInt(days, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ttl %q", s)
+		}
+		// Any more and it wraps around
+		if max := int64(math.MaxInt64 / (24 * time.Hour)); n > max || n < -max {
+			return 0, fmt.Errorf("ttl %q is too long", s)
+		}
+		return time.Duration(n) * time.Hour * 24, nil
+	}
+	ttl, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ttl %q", s)
+	}
+	return ttl, nil
+}
+
+func parseExpireDate(s string) (time.Time, error) {
+	for _, layout := range expireDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid expire date %q, use YYYY-MM-DD or RFC 3339", s)
+}
+
+// getExpireDate returns when an uploaded gob should expire, from the "ttl" or
+// "expire" upload parameter, or the default TTL in limits. The zero Time is
+// never. Errors are meant for the user.
+func getExpireDate(params url.Values, limits *Limits) (time.Time, error) {
+	now := time.Now()
+	ttlStr, expireStr := params.Get("ttl"), params.Get("expire")
+	var expireDate time.Time
+	switch {
+	case ttlStr != "" && expireStr != "":
+		return time.Time{}, fmt.Errorf("only one of ttl and expire can be given")
+	case ttlStr != "":
+		ttl, err := parseTTL(ttlStr)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if ttl <= 0 {
+			return time.Time{}, fmt.Errorf("ttl must be positive")
+		}
+		expireDate = now.Add(ttl)
+	case expireStr != "":
+		var err error
+		if expireDate, err = parseExpireDate(expireStr); err != nil {
+			return time.Time{}, err
+		}
+		if !expireDate.After(now) {
+			return time.Time{}, fmt.Errorf("expire date must be in the future")
+		}
+	case limits.DefaultTTL > 0:
+		expireDate = now.Add(limits.DefaultTTL)
+	}
+	if limits.MaxTTL > 0 {
+		if expireDate.IsZero() {
+			// Gobs can't be immortal when there is a max
+			expireDate = now.Add(limits.MaxTTL)
+		} else if expireDate.Sub(now) > limits.MaxTTL {
+			return time.Time{}, fmt.Errorf("gobs can live at most %s", limits.MaxTTL)
+		}
+	}
+	return expireDate, nil
+}
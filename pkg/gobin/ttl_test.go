@@ -0,0 +1,60 @@
+package gobin
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestGetExpireDate(t *testing.T) {
+	future := time.Now().Add(48 * time.Hour).UTC().Format(time.RFC3339)
+	for _, c := range []struct {
+		name   string
+		params url.Values
+		limits Limits
+		// want is how long from now the gob lives, 0 is forever
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "no ttl", params: url.Values{}},
+		{name: "days", params: url.Values{"ttl": {"7d"}}, want: 7 * 24 * time.Hour},
+		{name: "duration", params: url.Values{"ttl": {"1h"}}, want: time.Hour},
+		{name: "expire", params: url.Values{"expire": {future}}, want: 48 * time.Hour},
+		{name: "both", params: url.Values{"ttl": {"1h"}, "expire": {future}}, wantErr: true},
+		{name: "past expire", params: url.Values{"expire": {"2001-01-01"}}, wantErr: true},
+		{name: "bad expire", params: url.Values{"expire": {"tomorrow"}}, wantErr: true},
+		{name: "bad ttl", params: url.Values{"ttl": {"soon"}}, wantErr: true},
+		{name: "bad days", params: url.Values{"ttl": {"xd"}}, wantErr: true},
+		{name: "negative ttl", params: url.Values{"ttl": {"-1h"}}, wantErr: true},
+		{name: "days that overflow", params: url.Values{"ttl": {"106752d"}}, wantErr: true},
+		{name: "days that overflow negative", params: url.Values{"ttl": {"-106752d"}}, wantErr: true},
+		{name: "default", params: url.Values{}, limits: Limits{DefaultTTL: time.Hour}, want: time.Hour},
+		{name: "ttl over default", params: url.Values{"ttl": {"2h"}}, limits: Limits{DefaultTTL: time.Hour}, want: 2 * time.Hour},
+		{name: "max caps immortal", params: url.Values{}, limits: Limits{MaxTTL: 24 * time.Hour}, want: 24 * time.Hour},
+		{name: "under max", params: url.Values{"ttl": {"1h"}}, limits: Limits{MaxTTL: 24 * time.Hour}, want: time.Hour},
+		{name: "over max", params: url.Values{"ttl": {"2d"}}, limits: Limits{MaxTTL: 24 * time.Hour}, wantErr: true},
+		{name: "expire over max", params: url.Values{"expire": {future}}, limits: Limits{MaxTTL: 24 * time.Hour}, wantErr: true},
+	} {
+		now := time.Now()
+		got, err := getExpireDate(c.params, &c.limits)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: got %v, want an error", c.name, got)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if c.want == 0 {
+			if !got.IsZero() {
+				t.Errorf("%s: got %v, want never", c.name, got)
+			}
+			continue
+		}
+		// expire is only to the second
+		if d := got.Sub(now.Add(c.want)); d < -time.Second || d > time.Second {
+			t.Errorf("%s: got %v from now, want %v", c.name, got.Sub(now), c.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+
+	"github.com/levenlabs/errctx"
+)
+
+// Backends that can't encrypt server side encrypt objects themselves with
+// AES-256 in CTR mode. The random IV is written in front of the ciphertext.
+
+// newEncryptWriter returns a writer that encrypts everything written to it
+// with key before passing it on to w
+func newEncryptWriter(w io.Writer, key []byte) (io.Writer, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errctx.Mark(err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, errctx.Mark(err)
+	}
+	if _, err := w.Write(iv); err != nil {
+		return nil, errctx.Mark(err)
+	}
+	return &cipher.StreamWriter{S: cipher.NewCTR(block, iv), W: w}, nil
+}
+
+// newDecryptReader returns a reader that decrypts r, which must have been
+// written by a writer from newEncryptWriter
+func newDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errctx.Mark(err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(r, iv); err != nil {
+		return nil, errctx.Mark(err)
+	}
+	return &cipher.StreamReader{S: cipher.NewCTR(block, iv), R: r}, nil
+}
+
+// keySHA256 is stored alongside encrypted objects so that a wrong key can be
+// rejected before handing back garbage. Same idea as GCS's
+// x-goog-encryption-key-sha256.
+func keySHA256(key []byte) string {
+	if key == nil {
+		return ""
+	}
+	sum := sha256.Sum256(key)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,306 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/levenlabs/errctx"
+)
+
+// FSBackend stores objects as files in a directory tree on local disk.
+// Objects are sharded into subdirectories by the first two characters of
+// their path and their metadata is kept in a json sidecar file next to them.
+// Encryption is done client side, see crypt.go.
+type FSBackend struct {
+	root string
+	// guards read-modify-write of sidecar files
+	mu sync.Mutex
+}
+
+// fsSidecar is the contents of an object's .meta file
+type fsSidecar struct {
+	Created   time.Time         `json:"created"`
+	KeySHA256 string            `json:"keySHA256,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+const fsSidecarExt = ".meta"
+
+// NewFSBackend returns a Backend that keeps objects under the directory root,
+// creating it if needed
+func NewFSBackend(root string) (*FSBackend, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, errctx.Mark(err)
+	}
+	return &FSBackend{root: root}, nil
+}
+
+// filename returns where the object at path lives on disk
+func (b *FSBackend) filename(path string) (string, error) {
+	if path == "" || strings.ContainsAny(path, `/\`) || strings.HasPrefix(path, ".") {
+		return "", errctx.Mark(fmt.Errorf("invalid store path %q", path))
+	}
+	shard := path
+	if len(shard) > 2 {
+		shard = shard[:2]
+	}
+	return filepath.Join(b.root, shard, path), nil
+}
+
+// fsError turns a missing file into ErrNotFound
+func fsError(err error) error {
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return errctx.Mark(err)
+}
+
+func (b *FSBackend) readSidecar(filename string) (*fsSidecar, error) {
+	data, err := ioutil.ReadFile(filename + fsSidecarExt)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	sidecar := &fsSidecar{}
+	if err := json.Unmarshal(data, sidecar); err != nil {
+		return nil, errctx.Mark(err)
+	}
+	return sidecar, nil
+}
+
+func (b *FSBackend) writeSidecar(filename string, sidecar *fsSidecar) error {
+	data, err := json.Marshal(sidecar)
+	if err != nil {
+		return errctx.Mark(err)
+	}
+	return writeFileAtomic(filename+fsSidecarExt, data)
+}
+
+// writeFileAtomic writes data to a temp file and renames it to filename so
+// readers never see a partially written file
+func writeFileAtomic(filename string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return errctx.Mark(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return errctx.Mark(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return errctx.Mark(err)
+	}
+	if err := os.Rename(f.Name(), filename); err != nil {
+		os.Remove(f.Name())
+		return errctx.Mark(err)
+	}
+	return nil
+}
+
+// fsWriter writes to a temp file which is renamed into place on Close
+type fsWriter struct {
+	backend  *FSBackend
+	f        *os.File
+	w        io.Writer
+	filename string
+	sidecar  *fsSidecar
+	closed   bool
+	aborted  bool
+}
+
+func (w *fsWriter) Write(p []byte) (int, error) {
+	if w.aborted {
+		return 0, errAborted
+	}
+	return w.w.Write(p)
+}
+
+func (w *fsWriter) Abort() error {
+	if w.closed || w.aborted {
+		return nil
+	}
+	w.aborted = true
+	w.f.Close()
+	return errctx.Mark(os.Remove(w.f.Name()))
+}
+
+func (w *fsWriter) Close() error {
+	if w.aborted {
+		return errAborted
+	}
+	w.closed = true
+	tmpName := w.f.Name()
+	if err := w.f.Close(); err != nil {
+		os.Remove(tmpName)
+		return errctx.Mark(err)
+	}
+	// The sidecar goes first so that an object that exists always has one
+	w.backend.mu.Lock()
+	defer w.backend.mu.Unlock()
+	if err := w.backend.writeSidecar(w.filename, w.sidecar); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, w.filename); err != nil {
+		os.Remove(tmpName)
+		return errctx.Mark(err)
+	}
+	return nil
+}
+
+// fsReader closes the underlying file when done
+type fsReader struct {
+	io.Reader
+	f *os.File
+}
+
+func (r *fsReader) Close() error {
+	return r.f.Close()
+}
+
+func (b *FSBackend) NewWriter(ctx context.Context, path string, key []byte) (ObjectWriter, error) {
+	filename, err := b.filename(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return nil, errctx.Mark(err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+path+".tmp")
+	if err != nil {
+		return nil, errctx.Mark(err)
+	}
+	var w io.Writer = f
+	if key != nil {
+		if w, err = newEncryptWriter(f, key); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, err
+		}
+	}
+	return &fsWriter{
+		backend:  b,
+		f:        f,
+		w:        w,
+		filename: filename,
+		sidecar: &fsSidecar{
+			Created:   time.Now(),
+			KeySHA256: keySHA256(key),
+		},
+	}, nil
+}
+
+func (b *FSBackend) NewReader(ctx context.Context, path string, key []byte) (io.ReadCloser, error) {
+	filename, err := b.filename(path)
+	if err != nil {
+		return nil, err
+	}
+	sidecar, err := b.readSidecar(filename)
+	if err != nil {
+		return nil, err
+	}
+	if sidecar.KeySHA256 != keySHA256(key) {
+		return nil, ErrBadKey
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	var r io.Reader = f
+	if key != nil {
+		if r, err = newDecryptReader(f, key); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &fsReader{Reader: r, f: f}, nil
+}
+
+func (b *FSBackend) Exists(ctx context.Context, path string) (bool, error) {
+	filename, err := b.filename(path)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(filename)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errctx.Mark(err)
+	}
+	return true, nil
+}
+
+func (b *FSBackend) Delete(ctx context.Context, path string) error {
+	filename, err := b.filename(path)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := os.Remove(filename); err != nil {
+		return fsError(err)
+	}
+	if err := os.Remove(filename + fsSidecarExt); err != nil && !os.IsNotExist(err) {
+		return errctx.Mark(err)
+	}
+	return nil
+}
+
+func (b *FSBackend) Stat(ctx context.Context, path string) (*Attrs, error) {
+	filename, err := b.filename(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	sidecar, err := b.readSidecar(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &Attrs{
+		Size:     info.Size(),
+		Created:  sidecar.Created,
+		Metadata: sidecar.Metadata,
+	}, nil
+}
+
+// UpdateMetadata merges meta into the sidecar. Like GCS, a key set to the
+// empty string is removed.
+func (b *FSBackend) UpdateMetadata(ctx context.Context, path string, meta map[string]string) (map[string]string, error) {
+	filename, err := b.filename(path)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sidecar, err := b.readSidecar(filename)
+	if err != nil {
+		return nil, err
+	}
+	if sidecar.Metadata == nil {
+		sidecar.Metadata = map[string]string{}
+	}
+	for k, v := range meta {
+		if v == "" {
+			delete(sidecar.Metadata, k)
+			continue
+		}
+		sidecar.Metadata[k] = v
+	}
+	if err := b.writeSidecar(filename, sidecar); err != nil {
+		return nil, err
+	}
+	return sidecar.Metadata, nil
+}
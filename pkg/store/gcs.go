@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"github.com/levenlabs/errctx"
+	"google.golang.org/api/googleapi"
+)
+
+// GCSBackend stores objects in a google cloud storage bucket. Encryption keys
+// are passed to GCS as customer-supplied encryption keys.
+type GCSBackend struct {
+	bucket *storage.BucketHandle
+}
+
+// NewGCSBackend returns a Backend for the bucket bucketName. Credentials are
+// found the usual way, e.g. GOOGLE_APPLICATION_CREDENTIALS.
+func NewGCSBackend(ctx context.Context, bucketName string) (*GCSBackend, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &GCSBackend{
+		bucket: client.Bucket(bucketName),
+	}, nil
+}
+
+// gcsError turns GCS errors into ErrNotFound and ErrBadKey. GCS returns a 400
+// for a missing or wrong customer-supplied encryption key.
+func gcsError(err error) error {
+	if err == storage.ErrObjectNotExist {
+		return ErrNotFound
+	}
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusBadRequest {
+		return ErrBadKey
+	}
+	return errctx.Mark(err)
+}
+
+func (b *GCSBackend) object(path string, key []byte) *storage.ObjectHandle {
+	obj := b.bucket.Object(path)
+	if key != nil {
+		obj = obj.Key(key)
+	}
+	return obj
+}
+
+// gcsWriter aborts by canceling the context of the storage.Writer, which is
+// how GCS is told not to create the object
+type gcsWriter struct {
+	*storage.Writer
+	cancel  context.CancelFunc
+	closed  bool
+	aborted bool
+}
+
+func (w *gcsWriter) Abort() error {
+	if w.closed || w.aborted {
+		return nil
+	}
+	w.aborted = true
+	w.cancel()
+	// Only says it was canceled
+	w.Writer.Close()
+	return nil
+}
+
+func (w *gcsWriter) Close() error {
+	if w.aborted {
+		return errAborted
+	}
+	w.closed = true
+	defer w.cancel()
+	return w.Writer.Close()
+}
+
+func (b *GCSBackend) NewWriter(ctx context.Context, path string, key []byte) (ObjectWriter, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &gcsWriter{Writer: b.object(path, key).NewWriter(ctx), cancel: cancel}, nil
+}
+
+func (b *GCSBackend) NewReader(ctx context.Context, path string, key []byte) (io.ReadCloser, error) {
+	r, err := b.object(path, key).NewReader(ctx)
+	if err != nil {
+		return nil, gcsError(err)
+	}
+	return r, nil
+}
+
+func (b *GCSBackend) Exists(ctx context.Context, path string) (bool, error) {
+	// TODO: doesn't seem like a easy way to check objects existance
+	_, err := b.object(path, nil).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *GCSBackend) Delete(ctx context.Context, path string) error {
+	if err := b.object(path, nil).Delete(ctx); err != nil {
+		return gcsError(err)
+	}
+	return nil
+}
+
+func (b *GCSBackend) Stat(ctx context.Context, path string) (*Attrs, error) {
+	attrs, err := b.object(path, nil).Attrs(ctx)
+	if err != nil {
+		return nil, gcsError(err)
+	}
+	return &Attrs{
+		Size:     attrs.Size,
+		Created:  attrs.Created,
+		Metadata: attrs.Metadata,
+	}, nil
+}
+
+func (b *GCSBackend) UpdateMetadata(ctx context.Context, path string, meta map[string]string) (map[string]string, error) {
+	updateAttrs := storage.ObjectAttrsToUpdate{
+		Metadata: meta,
+	}
+	attrs, err := b.object(path, nil).Update(ctx, updateAttrs)
+	if err != nil {
+		return nil, gcsError(err)
+	}
+	return attrs.Metadata, nil
+}
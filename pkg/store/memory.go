@@ -0,0 +1,158 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// MemoryBackend keeps objects in memory. It's meant for tests and trying
+// gobin out, everything is lost when the process exits. Objects are not
+// actually encrypted, but the key is still checked on read.
+type MemoryBackend struct {
+	mu      sync.RWMutex
+	objects map[string]*memObject
+}
+
+type memObject struct {
+	data      []byte
+	keySHA256 string
+	created   time.Time
+	metadata  map[string]string
+}
+
+// NewMemoryBackend returns an empty *MemoryBackend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		objects: map[string]*memObject{},
+	}
+}
+
+// memWriter buffers writes and stores the object on Close
+type memWriter struct {
+	backend *MemoryBackend
+	path    string
+	buf     *bytes.Buffer
+	obj     *memObject
+	closed  bool
+	aborted bool
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	if w.aborted {
+		return 0, errAborted
+	}
+	return w.buf.Write(p)
+}
+
+func (w *memWriter) Abort() error {
+	w.aborted = !w.closed
+	return nil
+}
+
+func (w *memWriter) Close() error {
+	if w.aborted {
+		return errAborted
+	}
+	w.closed = true
+	w.obj.data = w.buf.Bytes()
+	w.backend.mu.Lock()
+	defer w.backend.mu.Unlock()
+	w.backend.objects[w.path] = w.obj
+	return nil
+}
+
+func (b *MemoryBackend) get(path string) (*memObject, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	obj, ok := b.objects[path]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return obj, nil
+}
+
+func (b *MemoryBackend) NewWriter(ctx context.Context, path string, key []byte) (ObjectWriter, error) {
+	return &memWriter{
+		backend: b,
+		path:    path,
+		buf:     &bytes.Buffer{},
+		obj: &memObject{
+			keySHA256: keySHA256(key),
+			created:   time.Now(),
+		},
+	}, nil
+}
+
+func (b *MemoryBackend) NewReader(ctx context.Context, path string, key []byte) (io.ReadCloser, error) {
+	obj, err := b.get(path)
+	if err != nil {
+		return nil, err
+	}
+	if obj.keySHA256 != keySHA256(key) {
+		return nil, ErrBadKey
+	}
+	return ioutil.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+func (b *MemoryBackend) Exists(ctx context.Context, path string) (bool, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.objects[path]
+	return ok, nil
+}
+
+func (b *MemoryBackend) Delete(ctx context.Context, path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.objects[path]; !ok {
+		return ErrNotFound
+	}
+	delete(b.objects, path)
+	return nil
+}
+
+func (b *MemoryBackend) Stat(ctx context.Context, path string) (*Attrs, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	obj, ok := b.objects[path]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	meta := make(map[string]string, len(obj.metadata))
+	for k, v := range obj.metadata {
+		meta[k] = v
+	}
+	return &Attrs{
+		Size:     int64(len(obj.data)),
+		Created:  obj.created,
+		Metadata: meta,
+	}, nil
+}
+
+func (b *MemoryBackend) UpdateMetadata(ctx context.Context, path string, meta map[string]string) (map[string]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	obj, ok := b.objects[path]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if obj.metadata == nil {
+		obj.metadata = map[string]string{}
+	}
+	for k, v := range meta {
+		if v == "" {
+			delete(obj.metadata, k)
+			continue
+		}
+		obj.metadata[k] = v
+	}
+	updated := make(map[string]string, len(obj.metadata))
+	for k, v := range obj.metadata {
+		updated[k] = v
+	}
+	return updated, nil
+}
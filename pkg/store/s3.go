@@ -0,0 +1,401 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/levenlabs/errctx"
+	minio "github.com/minio/minio-go"
+	"github.com/minio/minio-go/pkg/credentials"
+	"github.com/minio/minio-go/pkg/encrypt"
+)
+
+// S3Config is what's needed to connect to an S3 compatible service
+type S3Config struct {
+	// Endpoint is the host[:port] of the service, e.g. s3.amazonaws.com
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Insecure disables TLS, e.g. for a local MinIO or fake server
+	Insecure bool
+	// PartSize is how much is buffered before it is sent as one part of a
+	// multipart upload. Objects smaller than this are uploaded with a
+	// single PUT. S3 requires at least 5MiB.
+	PartSize int64
+}
+
+// DefaultS3PartSize is used when S3Config.PartSize is not set
+const DefaultS3PartSize = 16 << 20
+
+const minS3PartSize = 5 << 20
+
+// S3Backend stores objects in a bucket of anything that speaks the S3 API,
+// e.g. AWS, MinIO or Ceph RGW. Encryption keys are passed as SSE-C keys.
+//
+// SSE-C objects can't be HEADed or copied without their key, so Stat of an
+// encrypted object has no metadata and UpdateMetadata of one fails.
+type S3Backend struct {
+	endpoint string
+	opts     minio.Options
+	bucket   string
+	partSize int64
+}
+
+// NewS3Backend returns a Backend for the bucket in cfg, after checking with
+// ctx that it exists. Without a region it's looked up once here.
+func NewS3Backend(ctx context.Context, cfg S3Config) (*S3Backend, error) {
+	partSize := cfg.PartSize
+	if partSize == 0 {
+		partSize = DefaultS3PartSize
+	}
+	if partSize < minS3PartSize {
+		return nil, errctx.Mark(fmt.Errorf("s3 part size must be at least %d", minS3PartSize))
+	}
+	b := &S3Backend{
+		endpoint: cfg.Endpoint,
+		opts: minio.Options{
+			Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+			Secure: !cfg.Insecure,
+			Region: cfg.Region,
+		},
+		bucket:   cfg.Bucket,
+		partSize: partSize,
+	}
+	// Endpoint, credentials and bucket are only used by requests, so they're
+	// checked with one now rather than on the first upload
+	core, err := b.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exists, err := core.BucketExists(b.bucket); err != nil {
+		return nil, errctx.Mark(fmt.Errorf("failed to check s3 bucket %s: %v", b.bucket, err))
+	} else if !exists {
+		return nil, errctx.Mark(fmt.Errorf("s3 bucket %s doesn't exist", b.bucket))
+	}
+	// Each client would look it up again otherwise
+	if b.opts.Region == "" {
+		region, err := core.GetBucketLocation(b.bucket)
+		if err != nil {
+			return nil, errctx.Mark(fmt.Errorf("failed to get s3 bucket %s region: %v", b.bucket, err))
+		}
+		b.opts.Region = region
+	}
+	return b, nil
+}
+
+// ctxTransport makes every request with ctx
+type ctxTransport struct {
+	ctx context.Context
+}
+
+func (t ctxTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Checked first since minio retries some errors from canceled dials
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return minio.DefaultTransport.RoundTrip(req.WithContext(t.ctx))
+}
+
+// client returns a client that makes its requests with ctx. minio-go v6 only
+// has WithContext variants of a few calls, so the rest get ctx from the
+// transport. Clients are cheap, connections are pooled by the transport and
+// the region is pinned by NewS3Backend, so they don't look it up.
+func (b *S3Backend) client(ctx context.Context) (*minio.Core, error) {
+	opts := b.opts
+	client, err := minio.NewWithOptions(b.endpoint, &opts)
+	if err != nil {
+		return nil, errctx.Mark(err)
+	}
+	client.SetCustomTransport(ctxTransport{ctx})
+	return &minio.Core{Client: client}, nil
+}
+
+func newSSE(key []byte) (encrypt.ServerSide, error) {
+	if key == nil {
+		return nil, nil
+	}
+	sse, err := encrypt.NewSSEC(key)
+	return sse, errctx.Mark(err)
+}
+
+func isS3NotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+// s3ReadError turns errors from reading an object into ErrNotFound and, if
+// the read had an SSE-C key, ErrBadKey, since S3 denies reads of SSE-C
+// objects with the wrong one. Without a key a denial is S3's or the
+// credentials' problem, not the user's.
+func s3ReadError(err error, withKey bool) error {
+	if isS3NotFound(err) {
+		return ErrNotFound
+	}
+	resp := minio.ToErrorResponse(err)
+	if withKey && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusBadRequest) {
+		return ErrBadKey
+	}
+	return errctx.Mark(err)
+}
+
+// s3Writer buffers up to partSize and then switches to a multipart upload,
+// sending a part every time the buffer fills
+type s3Writer struct {
+	ctx      context.Context
+	core     *minio.Core
+	backend  *S3Backend
+	path     string
+	sse      encrypt.ServerSide
+	buf      *bytes.Buffer
+	uploadID string
+	parts    []minio.CompletePart
+	err      error
+	closed   bool
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, _ := w.buf.Write(p)
+	for int64(w.buf.Len()) >= w.backend.partSize {
+		if w.err = w.flushPart(w.buf.Next(int(w.backend.partSize))); w.err != nil {
+			w.abort()
+			return n, w.err
+		}
+	}
+	return n, nil
+}
+
+func (w *s3Writer) flushPart(part []byte) error {
+	if err := w.ctx.Err(); err != nil {
+		return errctx.Mark(err)
+	}
+	if w.uploadID == "" {
+		opts := minio.PutObjectOptions{ServerSideEncryption: w.sse}
+		uploadID, err := w.core.NewMultipartUpload(w.backend.bucket, w.path, opts)
+		if err != nil {
+			return errctx.Mark(err)
+		}
+		w.uploadID = uploadID
+	}
+	partID := len(w.parts) + 1
+	objPart, err := w.core.PutObjectPart(w.backend.bucket, w.path, w.uploadID, partID,
+		bytes.NewReader(part), int64(len(part)), "", "", w.sse)
+	if err != nil {
+		return errctx.Mark(err)
+	}
+	w.parts = append(w.parts, minio.CompletePart{PartNumber: partID, ETag: objPart.ETag})
+	return nil
+}
+
+// abort cleans up the multipart upload, even if ctx is done
+func (w *s3Writer) abort() {
+	if w.uploadID == "" {
+		return
+	}
+	if core, err := w.backend.client(context.Background()); err == nil {
+		core.AbortMultipartUpload(w.backend.bucket, w.path, w.uploadID)
+	}
+}
+
+// Abort throws away the parts sent so far. Nothing is visible until Close
+// completes the upload, so that's all there is to do. A failed Write already
+// did.
+func (w *s3Writer) Abort() error {
+	if w.closed || w.err != nil {
+		return nil
+	}
+	w.err = errAborted
+	w.abort()
+	return nil
+}
+
+func (w *s3Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.closed = true
+	if err := w.ctx.Err(); err != nil {
+		w.abort()
+		return errctx.Mark(err)
+	}
+	// Small enough for a single PUT
+	if w.uploadID == "" {
+		_, err := w.core.PutObject(w.backend.bucket, w.path, w.buf, int64(w.buf.Len()), "", "", nil, w.sse)
+		return errctx.Mark(err)
+	}
+	if w.buf.Len() > 0 {
+		if err := w.flushPart(w.buf.Bytes()); err != nil {
+			w.abort()
+			return err
+		}
+	}
+	if _, err := w.core.CompleteMultipartUpload(w.backend.bucket, w.path, w.uploadID, w.parts); err != nil {
+		w.abort()
+		return errctx.Mark(err)
+	}
+	return nil
+}
+
+func (b *S3Backend) NewWriter(ctx context.Context, path string, key []byte) (ObjectWriter, error) {
+	sse, err := newSSE(key)
+	if err != nil {
+		return nil, err
+	}
+	core, err := b.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Writer{
+		ctx:     ctx,
+		core:    core,
+		backend: b,
+		path:    path,
+		sse:     sse,
+		buf:     &bytes.Buffer{},
+	}, nil
+}
+
+func (b *S3Backend) NewReader(ctx context.Context, path string, key []byte) (io.ReadCloser, error) {
+	sse, err := newSSE(key)
+	if err != nil {
+		return nil, err
+	}
+	core, err := b.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	opts := minio.GetObjectOptions{ServerSideEncryption: sse}
+	obj, err := core.GetObjectWithContext(ctx, b.bucket, path, opts)
+	if err != nil {
+		return nil, s3ReadError(err, key != nil)
+	}
+	// The object is fetched lazily, Stat makes sure it's there and readable
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, s3ReadError(err, key != nil)
+	}
+	return obj, nil
+}
+
+// list returns the listing entry of path, which unlike a HEAD doesn't need
+// the SSE-C key
+func (b *S3Backend) list(ctx context.Context, path string) (*minio.ObjectInfo, error) {
+	core, err := b.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := core.ListObjectsV2(b.bucket, path, "", false, "", 1, "")
+	if err != nil {
+		return nil, errctx.Mark(err)
+	}
+	for _, info := range result.Contents {
+		if info.Key == path {
+			return &info, nil
+		}
+	}
+	return nil, nil
+}
+
+func (b *S3Backend) Exists(ctx context.Context, path string) (bool, error) {
+	info, err := b.list(ctx, path)
+	if err != nil {
+		return false, err
+	}
+	return info != nil, nil
+}
+
+// Delete returns ErrNotFound if there's no object at path, which S3 doesn't
+// tell us itself
+func (b *S3Backend) Delete(ctx context.Context, path string) error {
+	if exists, err := b.Exists(ctx, path); err != nil {
+		return err
+	} else if !exists {
+		return ErrNotFound
+	}
+	core, err := b.client(ctx)
+	if err != nil {
+		return err
+	}
+	return errctx.Mark(core.RemoveObject(b.bucket, path))
+}
+
+func (b *S3Backend) Stat(ctx context.Context, path string) (*Attrs, error) {
+	core, err := b.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	info, err := core.StatObject(b.bucket, path, minio.StatObjectOptions{})
+	if err == nil {
+		return &Attrs{
+			Size:     info.Size,
+			Created:  info.LastModified,
+			Metadata: userMetadata(info.Metadata),
+		}, nil
+	}
+	if isS3NotFound(err) {
+		return nil, ErrNotFound
+	}
+	// Probably SSE-C, fall back to what the listing knows
+	listInfo, listErr := b.list(ctx, path)
+	if listErr != nil || listInfo == nil {
+		return nil, errctx.Mark(err)
+	}
+	return &Attrs{
+		Size:    listInfo.Size,
+		Created: listInfo.LastModified,
+	}, nil
+}
+
+const s3MetaPrefix = "X-Amz-Meta-"
+
+// userMetadata pulls the x-amz-meta-* headers out of h
+func userMetadata(h http.Header) map[string]string {
+	meta := map[string]string{}
+	for k := range h {
+		if strings.HasPrefix(k, s3MetaPrefix) {
+			meta[strings.ToLower(strings.TrimPrefix(k, s3MetaPrefix))] = h.Get(k)
+		}
+	}
+	return meta
+}
+
+// UpdateMetadata copies the object onto itself with the merged metadata,
+// which is the only way S3 has to change it
+func (b *S3Backend) UpdateMetadata(ctx context.Context, path string, meta map[string]string) (map[string]string, error) {
+	attrs, err := b.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	merged := attrs.Metadata
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	for k, v := range meta {
+		k = strings.ToLower(k)
+		if v == "" {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+	headers := map[string]string{"x-amz-metadata-directive": "REPLACE"}
+	for k, v := range merged {
+		headers[s3MetaPrefix+k] = v
+	}
+	core, err := b.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := core.CopyObject(b.bucket, path, b.bucket, path, headers); err != nil {
+		return nil, errctx.Mark(err)
+	}
+	return merged, nil
+}
@@ -0,0 +1,333 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is just enough of S3 for S3Backend, the bucket "bkt" kept in memory
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	meta    map[string]map[string]string
+	parts   map[string]map[int][]byte
+	// locations counts GetBucketLocation requests
+	locations int
+	// deny makes reads of objects fail like a bucket policy denying them
+	deny bool
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q := r.URL.Query()
+	p := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	key := ""
+	if len(p) == 2 {
+		key = p[1]
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	// Signed streaming uploads are sent as chunks of size;signature
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING") {
+		var out []byte
+		rest := body
+		for {
+			i := bytes.Index(rest, []byte("\r\n"))
+			var n int
+			fmt.Sscanf(string(rest[:i]), "%x", &n)
+			rest = rest[i+2:]
+			if n == 0 {
+				break
+			}
+			out = append(out, rest[:n]...)
+			rest = rest[n+2:]
+		}
+		body = out
+	}
+	switch {
+	case p[0] != "bkt":
+		w.WriteHeader(404)
+		if r.Method != "HEAD" {
+			fmt.Fprint(w, `<Error><Code>NoSuchBucket</Code><Message>nope</Message></Error>`)
+		}
+	case r.Method == "HEAD" && key == "":
+	case r.Method == "GET" && key == "" && q.Has("location"):
+		f.locations++
+		fmt.Fprint(w, `<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">eu-west-1</LocationConstraint>`)
+	case r.Method == "GET" && key == "" && q.Get("list-type") == "2":
+		var keys []string
+		for k := range f.objects {
+			if strings.HasPrefix(k, q.Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>b</Name>`)
+		for _, k := range keys {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>2019-01-01T00:00:00.000Z</LastModified></Contents>", k, len(f.objects[k]))
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+	case r.Method == "POST" && q.Has("uploads"):
+		f.parts[key] = map[int][]byte{}
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Bucket>b</Bucket><Key>%s</Key><UploadId>up1</UploadId></InitiateMultipartUploadResult>`, key)
+	case r.Method == "PUT" && q.Get("uploadId") != "":
+		var n int
+		fmt.Sscan(q.Get("partNumber"), &n)
+		f.parts[key][n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag%d"`, n))
+	case r.Method == "POST" && q.Get("uploadId") != "":
+		var all []byte
+		for i := 1; i <= len(f.parts[key]); i++ {
+			all = append(all, f.parts[key][i]...)
+		}
+		f.objects[key] = all
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Bucket>bkt</Bucket><Key>%s</Key><ETag>"x"</ETag></CompleteMultipartUploadResult>`, key)
+	case r.Method == "PUT" && r.Header.Get("x-amz-copy-source") != "":
+		m := map[string]string{}
+		for k := range r.Header {
+			if strings.HasPrefix(k, "X-Amz-Meta-") {
+				m[k] = r.Header.Get(k)
+			}
+		}
+		f.meta[key] = m
+		fmt.Fprint(w, `<CopyObjectResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><ETag>"x"</ETag><LastModified>2019-01-01T00:00:00.000Z</LastModified></CopyObjectResult>`)
+	case r.Method == "PUT":
+		f.objects[key] = body
+		w.Header().Set("ETag", `"x"`)
+	case (r.Method == "GET" || r.Method == "HEAD") && f.deny:
+		w.WriteHeader(403)
+		if r.Method == "GET" {
+			fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>denied</Message></Error>`)
+		}
+	case r.Method == "GET" || r.Method == "HEAD":
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(404)
+			if r.Method == "GET" {
+				fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>nope</Message></Error>`)
+			}
+			return
+		}
+		for k, v := range f.meta[key] {
+			w.Header().Set(k, v)
+		}
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2019 00:00:00 GMT")
+		w.Header().Set("ETag", `"x"`)
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		if r.Method == "GET" {
+			w.Write(data)
+		}
+	case r.Method == "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(204)
+	default:
+		w.WriteHeader(500)
+	}
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3Backend) {
+	fake, cfg := newFakeS3Server(t)
+	b, err := NewS3Backend(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, b
+}
+
+// newFakeS3Server returns a fakeS3 and the config of a backend for it
+func newFakeS3Server(t *testing.T) (*fakeS3, S3Config) {
+	fake := &fakeS3{
+		objects: map[string][]byte{},
+		meta:    map[string]map[string]string{},
+		parts:   map[string]map[int][]byte{},
+	}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return fake, S3Config{
+		Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		Region:    "us-east-1",
+		Bucket:    "bkt",
+		AccessKey: "access",
+		SecretKey: "secret",
+		Insecure:  true,
+		PartSize:  minS3PartSize,
+	}
+}
+
+func TestNewS3Backend(t *testing.T) {
+	fake, cfg := newFakeS3Server(t)
+	ctx := context.Background()
+	bad := cfg
+	bad.Bucket = "nope"
+	if _, err := NewS3Backend(ctx, bad); err == nil {
+		t.Fatal("got a backend for a missing bucket")
+	}
+
+	// The region is looked up once, not by every request
+	cfg.Region = ""
+	b, err := NewS3Backend(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	} else if b.opts.Region != "eu-west-1" {
+		t.Fatalf("got region %q", b.opts.Region)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := b.Exists(ctx, "abc"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.locations != 1 {
+		t.Fatalf("looked up the region %d times", fake.locations)
+	}
+}
+
+func TestS3ReadDenied(t *testing.T) {
+	fake, b := newFakeS3(t)
+	ctx := context.Background()
+	w, _ := b.NewWriter(ctx, "abc", nil)
+	w.Write([]byte("hello"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fake.deny = true
+	// Only a read with a key can have the wrong one
+	if _, err := b.NewReader(ctx, "abc", nil); err == nil || err == ErrBadKey {
+		t.Fatalf("got %v without a key, want S3's error", err)
+	}
+	if _, err := b.NewReader(ctx, "abc", bytes.Repeat([]byte{'k'}, 32)); err != ErrBadKey {
+		t.Fatalf("got %v with a key, want ErrBadKey", err)
+	}
+}
+
+func TestS3WriteRead(t *testing.T) {
+	fake, b := newFakeS3(t)
+	ctx := context.Background()
+	// A single PUT, and a multipart upload of a few parts
+	for _, size := range []int{10, 2*minS3PartSize + 10} {
+		data := bytes.Repeat([]byte{'x'}, size)
+		w, err := b.NewWriter(ctx, "abc", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data[:size/2])
+		w.Write(data[size/2:])
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if size > minS3PartSize && len(fake.parts["abc"]) != 3 {
+			t.Fatalf("got %d parts, want 3", len(fake.parts["abc"]))
+		}
+		r, err := b.NewReader(ctx, "abc", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(got, data) {
+			t.Fatalf("read %d bytes, want %d", len(got), size)
+		}
+	}
+	if _, err := b.NewReader(ctx, "nope", nil); err != ErrNotFound {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3ExistsDelete(t *testing.T) {
+	_, b := newFakeS3(t)
+	ctx := context.Background()
+	w, _ := b.NewWriter(ctx, "abc", nil)
+	w.Write([]byte("hello"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{"abc": true, "ab": false, "abcd": false} {
+		if exists, err := b.Exists(ctx, path); err != nil {
+			t.Fatal(err)
+		} else if exists != want {
+			t.Fatalf("%s exists is %v, want %v", path, exists, want)
+		}
+	}
+	if err := b.Delete(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := b.Exists(ctx, "abc"); err != nil || exists {
+		t.Fatalf("got %v %v after delete", exists, err)
+	}
+	if err := b.Delete(ctx, "abc"); err != ErrNotFound {
+		t.Fatalf("got %v, want ErrNotFound deleting twice", err)
+	}
+}
+
+func TestS3Metadata(t *testing.T) {
+	_, b := newFakeS3(t)
+	ctx := context.Background()
+	w, _ := b.NewWriter(ctx, "abc", nil)
+	w.Write([]byte("hello"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.UpdateMetadata(ctx, "abc", map[string]string{"Foo": "bar", "baz": "qux"}); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := b.UpdateMetadata(ctx, "abc", map[string]string{"baz": ""})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(meta) != 1 || meta["foo"] != "bar" {
+		t.Fatalf("got %v", meta)
+	}
+	attrs, err := b.Stat(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	} else if attrs.Size != 5 || attrs.Metadata["foo"] != "bar" {
+		t.Fatalf("got %+v", attrs)
+	}
+	if _, err := b.Stat(ctx, "nope"); err != ErrNotFound {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3Context(t *testing.T) {
+	// Never answers but the bucket check, so only ctx can end the request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" && strings.TrimSuffix(r.URL.Path, "/") == "/bkt" {
+			return
+		}
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	b, err := NewS3Backend(context.Background(), S3Config{
+		Endpoint: strings.TrimPrefix(srv.URL, "http://"),
+		Region:   "us-east-1",
+		Bucket:   "bkt",
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Exists(ctx, "abc")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Exists succeeded without an answer")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Exists ignored ctx")
+	}
+}
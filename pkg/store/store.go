@@ -2,27 +2,78 @@ package store
 
 import (
 	"context"
+	"errors"
 	"io"
+	"time"
 
-	"cloud.google.com/go/storage"
 	"github.com/DataDog/zstd"
 	"github.com/levenlabs/errctx"
 	"golang.org/x/crypto/scrypt"
 )
 
+var (
+	// ErrNotFound is returned by Backends when there is no object at the path
+	ErrNotFound = errors.New("store object not found")
+	// ErrBadKey is returned by Backends when an object is read with the wrong
+	// encryption key, or none
+	ErrBadKey = errors.New("store object encryption key does not match")
+
+	// errAborted is returned by writers used after Abort
+	errAborted = errors.New("store object writer was aborted")
+)
+
+// Backend is a storage service that gob objects are kept in. Implementations
+// store the bytes they are given as-is; compression is layered on top by
+// Object. A non-nil key means the object is encrypted with that key, and the
+// same key must be given to read it back.
+type Backend interface {
+	// NewWriter returns a writer that creates or replaces the object at path.
+	// The object is not guaranteed to be visible until the writer is closed.
+	NewWriter(ctx context.Context, path string, key []byte) (ObjectWriter, error)
+	// NewReader returns a reader of the object at path
+	NewReader(ctx context.Context, path string, key []byte) (io.ReadCloser, error)
+	Exists(ctx context.Context, path string) (bool, error)
+	Delete(ctx context.Context, path string) error
+	Stat(ctx context.Context, path string) (*Attrs, error)
+	// UpdateMetadata merges meta into the object's metadata and returns the result
+	UpdateMetadata(ctx context.Context, path string, meta map[string]string) (map[string]string, error)
+}
+
+// ObjectWriter writes an object. Close commits it, Abort throws away what was
+// written and leaves whatever was at the path before.
+type ObjectWriter interface {
+	io.WriteCloser
+	// Abort does nothing after Close, and Close after Abort returns an error
+	// without committing anything
+	Abort() error
+}
+
+// Attrs of a stored object
+type Attrs struct {
+	// Size of the object as stored, i.e. compressed
+	Size     int64
+	Created  time.Time
+	Metadata map[string]string
+}
+
 type Writer struct {
-	writer  io.WriteCloser
-	closers []io.WriteCloser
+	writer  io.Writer
+	closers []io.Closer
+	// object is the Backend's writer, closers ends with it
+	object ObjectWriter
+	done   bool
 }
 
 type Reader struct {
-	reader  io.ReadCloser
-	closers []io.ReadCloser
+	reader  io.Reader
+	closers []io.Closer
 }
 
-// Object wraps google storage.ObjectHandle
+// Object is a handle to a single object in a Backend
 type Object struct {
-	*storage.ObjectHandle
+	backend Backend
+	path    string
+	key     []byte
 }
 
 // used to inline the Reader interface
@@ -50,16 +101,38 @@ func (w *Writer) Write(p []byte) (int, error) {
 	return w.writer.Write(p)
 }
 
-// Close all writers
+// Close all writers, which commits the object
 func (w *Writer) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
 	for _, closer := range w.closers {
 		if err := closer.Close(); err != nil {
+			// Nothing half written is committed, this does nothing if it
+			// was the object that failed to close
+			w.object.Abort()
 			return err
 		}
 	}
 	return nil
 }
 
+// Abort throws the object away instead of committing it. It does nothing
+// after Close, so it can be deferred.
+func (w *Writer) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	err := w.object.Abort()
+	// The rest are closed to free them, what they write is thrown away
+	for _, closer := range w.closers[:len(w.closers)-1] {
+		closer.Close()
+	}
+	return err
+}
+
 // Read reades a compressed form of p to the underlying io.Reader.
 func (w *Reader) Read(p []byte) (int, error) {
 	return w.reader.Read(p)
@@ -79,75 +152,152 @@ func NewKey(pass string, salt string) ([]byte, error) {
 	return scrypt.Key([]byte(pass), []byte(salt), 32768, 8, 1, 32)
 }
 
-func NewObject(ctx context.Context, bucketName string, path string) (*Object, error) {
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return nil, err
-	}
-	bkt := client.Bucket(bucketName)
+// NewObject returns a handle to the object at path in backend
+func NewObject(backend Backend, path string) *Object {
 	return &Object{
-		bkt.Object(path),
-	}, nil
+		backend: backend,
+		path:    path,
+	}
 }
 
-func (obj *Object) NewWriter(ctx context.Context) *Writer {
-	w := obj.ObjectHandle.NewWriter(ctx)
+func (obj *Object) NewWriter(ctx context.Context) (*Writer, error) {
+	w, err := obj.backend.NewWriter(ctx, obj.path, obj.key)
+	if err != nil {
+		return nil, err
+	}
 	zw := zstd.NewWriter(w)
 	return &Writer{
 		writer:  zw,
-		closers: []io.WriteCloser{zw, w},
-	}
+		closers: []io.Closer{zw, w},
+		object:  w,
+	}, nil
 }
 
 func (obj *Object) NewReader(ctx context.Context) (*Reader, error) {
-	r, err := obj.ObjectHandle.NewReader(ctx)
+	r, err := obj.backend.NewReader(ctx, obj.path, obj.key)
 	if err != nil {
 		return nil, err
 	}
 	zr := zstd.NewReader(r)
 	return &Reader{
 		reader:  zr,
-		closers: []io.ReadCloser{zr, r},
+		closers: []io.Closer{zr, r},
 	}, nil
 
 }
 
+// NewCompressedReader returns a reader of the object as it's stored, i.e.
+// zstd compressed
+func (obj *Object) NewCompressedReader(ctx context.Context) (io.ReadCloser, error) {
+	return obj.backend.NewReader(ctx, obj.path, obj.key)
+}
+
+// WithPath returns a handle to the object at path in the same backend, with
+// obj's key
+func (obj *Object) WithPath(path string) *Object {
+	return &Object{
+		backend: obj.backend,
+		path:    path,
+		key:     obj.key,
+	}
+}
+
+// NewObjectsReader returns a reader of objs one after the other, like
+// NewReader of one object. Each is a whole zstd stream, so their compressed
+// bytes are joined and decompressed together.
+func NewObjectsReader(ctx context.Context, objs []*Object) (*Reader, error) {
+	r, err := NewObjectsCompressedReader(ctx, objs)
+	if err != nil {
+		return nil, err
+	}
+	zr := zstd.NewReader(r)
+	return &Reader{
+		reader:  zr,
+		closers: []io.Closer{zr, r},
+	}, nil
+}
+
+// NewObjectsCompressedReader is NewObjectsReader without decompressing. The
+// first object is opened now, so a missing one or a wrong key is found before
+// anything is read, and the rest as they're reached.
+func NewObjectsCompressedReader(ctx context.Context, objs []*Object) (io.ReadCloser, error) {
+	r, err := objs[0].NewCompressedReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &objectsReader{ctx: ctx, objs: objs[1:], r: r}, nil
+}
+
+// objectsReader reads r and then each of objs
+type objectsReader struct {
+	ctx  context.Context
+	objs []*Object
+	r    io.ReadCloser
+}
+
+func (or *objectsReader) Read(p []byte) (int, error) {
+	for {
+		if or.r == nil {
+			if len(or.objs) == 0 {
+				return 0, io.EOF
+			}
+			r, err := or.objs[0].NewCompressedReader(or.ctx)
+			if err != nil {
+				return 0, err
+			}
+			or.r, or.objs = r, or.objs[1:]
+		}
+		n, err := or.r.Read(p)
+		if err == io.EOF {
+			err = or.r.Close()
+			or.r = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (or *objectsReader) Close() error {
+	if or.r == nil {
+		return nil
+	}
+	err := or.r.Close()
+	or.r = nil
+	return err
+}
+
 func (obj *Object) Key(pass string, salt string) error {
 	key, err := NewKey(pass, salt)
 	if err != nil {
 		return err
 	}
-	obj.ObjectHandle = obj.ObjectHandle.Key(key)
+	obj.key = key
 	return nil
 }
 
 func (obj *Object) Metadata(ctx context.Context) (map[string]string, error) {
-	attrs, err := obj.Attrs(ctx)
+	attrs, err := obj.backend.Stat(ctx, obj.path)
 	if err != nil {
 		return nil, err
 	}
 	return attrs.Metadata, nil
 }
 
+// Stat returns the attributes of the object as stored, so Size is what it
+// takes up in the store
+func (obj *Object) Stat(ctx context.Context) (*Attrs, error) {
+	return obj.backend.Stat(ctx, obj.path)
+}
+
 func (obj *Object) Exists(ctx context.Context) (bool, error) {
-	// TODO: doesn't seem like a easy way to check objects existance
-	_, err := obj.Attrs(ctx)
-	if err == storage.ErrObjectNotExist {
-		return false, nil
-	}
-	if err != nil {
-		return false, err
-	}
-	return true, nil
+	return obj.backend.Exists(ctx, obj.path)
+}
+
+func (obj *Object) Delete(ctx context.Context) error {
+	return obj.backend.Delete(ctx, obj.path)
 }
 
 func (obj *Object) UpdateMetadata(ctx context.Context, meta map[string]string) (map[string]string, error) {
-	updateAttrs := storage.ObjectAttrsToUpdate{
-		Metadata: meta,
-	}
-	attrs, err := obj.Update(ctx, updateAttrs)
-	if err != nil {
-		return nil, err
-	}
-	return attrs.Metadata, nil
+	return obj.backend.UpdateMetadata(ctx, obj.path, meta)
 }
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func testBackends(t *testing.T) map[string]Backend {
+	fs, err := NewFSBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, s3 := newFakeS3(t)
+	return map[string]Backend{"memory": NewMemoryBackend(), "fs": fs, "s3": s3}
+}
+
+func writeObject(ctx context.Context, obj *Object, content string) error {
+	w, err := obj.NewWriter(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(content)); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Close()
+}
+
+func readObject(ctx context.Context, obj *Object) (string, error) {
+	r, err := obj.NewReader(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	buf := &bytes.Buffer{}
+	_, err = buf.ReadFrom(r)
+	return buf.String(), err
+}
+
+func TestWriterAbort(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBackends(t) {
+		obj := NewObject(b, "abc")
+		// Aborting a new object leaves nothing behind
+		w, err := obj.NewWriter(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("never seen"))
+		if err := w.Abort(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if exists, err := obj.Exists(ctx); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		} else if exists {
+			t.Fatalf("%s: aborted object exists", name)
+		}
+
+		// Aborting a rewrite leaves the old content
+		if err := writeObject(ctx, obj, "old"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		w, err = obj.NewWriter(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(strings.Repeat("new", 100)))
+		w.Abort()
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: got %v closing after abort", name, err)
+		}
+		if got, err := readObject(ctx, obj); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		} else if got != "old" {
+			t.Fatalf("%s: got %q after abort, want the old content", name, got)
+		}
+
+		// And abort after close changes nothing
+		if err := writeObject(ctx, obj, "committed"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		w, _ = obj.NewWriter(ctx)
+		w.Write([]byte("final"))
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		w.Abort()
+		if got, err := readObject(ctx, obj); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		} else if got != "final" {
+			t.Fatalf("%s: got %q", name, got)
+		}
+	}
+}
+
+func TestObjectsReader(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBackends(t) {
+		var objs []*Object
+		for i, content := range []string{"one ", "", "two ", "three"} {
+			obj := NewObject(b, fmt.Sprintf("abc.%d", i))
+			if err := writeObject(ctx, obj, content); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			objs = append(objs, obj)
+		}
+		r, err := NewObjectsReader(ctx, objs)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		} else if string(got) != "one two three" {
+			t.Fatalf("%s: got %q", name, got)
+		}
+
+		// A part that's gone is only found when it's reached
+		objs = append(objs, NewObject(b, "nope"))
+		r, err = NewObjectsReader(ctx, objs)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := ioutil.ReadAll(r); err == nil {
+			t.Fatalf("%s: read a missing part", name)
+		}
+		r.Close()
+		if _, err := NewObjectsReader(ctx, objs[len(objs)-1:]); err != ErrNotFound {
+			t.Fatalf("%s: got %v, want ErrNotFound", name, err)
+		}
+	}
+}
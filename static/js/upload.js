@@ -0,0 +1,35 @@
+// Upload form: a chosen or dropped file is uploaded instead of the textarea,
+// which is disabled so only one "g" is posted
+(function() {
+    var text = document.getElementById('gob-text');
+    var file = document.getElementById('gob-file');
+    var filename = document.querySelector('#upload input[name="f"]');
+
+    function fileChanged() {
+        var chosen = file.files.length > 0;
+        text.disabled = chosen;
+        if (chosen && filename.value === '') {
+            filename.placeholder = file.files[0].name;
+        }
+    }
+    file.addEventListener('change', fileChanged);
+
+    document.body.addEventListener('dragover', function(e) {
+        e.preventDefault();
+        document.body.classList.add('dragging');
+    });
+    document.body.addEventListener('dragleave', function(e) {
+        if (e.target === document.body) {
+            document.body.classList.remove('dragging');
+        }
+    });
+    document.body.addEventListener('drop', function(e) {
+        e.preventDefault();
+        document.body.classList.remove('dragging');
+        if (e.dataTransfer.files.length === 0) {
+            return;
+        }
+        file.files = e.dataTransfer.files;
+        fileChanged();
+    });
+})();